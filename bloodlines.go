@@ -0,0 +1,169 @@
+package vpk
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"os"
+)
+
+// bloodlinesfooter is the last 9 bytes of a VPK file from Vampire: The
+// Masquerade - Bloodlines. These files are unrelated to Valve's VPK format.
+// Each file (pack000.vpk, pack001.vpk, ...) is self-contained: the file data
+// comes first, followed by the directory and then the footer.
+type bloodlinesfooter struct {
+	// The number of files in the directory.
+	FileCount uint32
+	// Unknown. Always 0 in the files that ship with the game.
+	Unknown uint8
+	// The offset of the directory from the beginning of the file.
+	DirectoryOffset uint32
+}
+
+const bloodlinesFooterLength = 9
+
+// bloodlinesMaxNameLength is the longest file name that will be accepted
+// while detecting a Bloodlines VPK. The game's own paths are much shorter.
+const bloodlinesMaxNameLength = 1024
+
+// readBloodlines attempts to read the directory of a Bloodlines VPK from f,
+// which is size bytes long. If f does not have the structure of a Bloodlines
+// VPK, ok is false.
+func readBloodlines(f File, size int64, limits *Limits) (entries entrysort, ok bool, err error) {
+	if size < bloodlinesFooterLength {
+		return nil, false, nil
+	}
+	end := size - bloodlinesFooterLength
+
+	_, err = f.Seek(end, os.SEEK_SET)
+	if err != nil {
+		return nil, false, err
+	}
+
+	var footer bloodlinesfooter
+	err = binary.Read(f, binary.LittleEndian, &footer)
+	if err != nil {
+		return nil, false, err
+	}
+
+	// Each directory entry is at least 12 bytes long.
+	if int64(footer.DirectoryOffset) > end || int64(footer.FileCount) > (end-int64(footer.DirectoryOffset))/12 {
+		return nil, false, nil
+	}
+
+	dirLength := end - int64(footer.DirectoryOffset)
+
+	// scan reads the directory, calling fn for each file if it is not nil.
+	// It returns the length of the longest name, or ok=false if the
+	// directory does not have the structure of a Bloodlines VPK.
+	scan := func(fn func(name []byte, e vpkentry)) (maxName int, ok bool, err error) {
+		_, err = f.Seek(int64(footer.DirectoryOffset), os.SEEK_SET)
+		if err != nil {
+			return 0, false, err
+		}
+
+		r := &countingReader{r: bufio.NewReader(io.LimitReader(f, dirLength))}
+		name := make([]byte, bloodlinesMaxNameLength)
+
+		for i := uint32(0); i < footer.FileCount; i++ {
+			var nameLength uint32
+			if err = binary.Read(r, binary.LittleEndian, &nameLength); err != nil {
+				return 0, false, nil
+			}
+			if nameLength == 0 || nameLength > bloodlinesMaxNameLength {
+				return 0, false, nil
+			}
+			if int(nameLength) > maxName {
+				maxName = int(nameLength)
+			}
+
+			if _, err = io.ReadFull(r, name[:nameLength]); err != nil {
+				return 0, false, nil
+			}
+
+			var e vpkentry
+			if err = binary.Read(r, binary.LittleEndian, &e.Offset); err != nil {
+				return 0, false, nil
+			}
+			if err = binary.Read(r, binary.LittleEndian, &e.Length); err != nil {
+				return 0, false, nil
+			}
+			if int64(e.Offset)+int64(e.Length) > int64(footer.DirectoryOffset) {
+				return 0, false, nil
+			}
+			e.ArchiveIndex = 0x7fff
+			e.Terminator = 0xffff
+
+			if fn != nil {
+				fn(name[:nameLength], e)
+			}
+		}
+
+		return maxName, r.n == dirLength, nil
+	}
+
+	// Make sure this is really a Bloodlines VPK before checking the limits,
+	// so that other files are not rejected just because their last few
+	// bytes happen to look like a footer.
+	maxName, ok, err := scan(nil)
+	if !ok || err != nil {
+		return nil, false, err
+	}
+
+	if limits.MaxEntries != 0 && int64(footer.FileCount) > int64(limits.MaxEntries) {
+		return nil, false, ErrLimitExceeded{Limit: "MaxEntries", Max: int64(limits.MaxEntries)}
+	}
+	if limits.MaxTreeLength != 0 && dirLength > limits.MaxTreeLength {
+		return nil, false, ErrLimitExceeded{Limit: "MaxTreeLength", Max: limits.MaxTreeLength}
+	}
+	if limits.MaxStringLength != 0 && maxName > limits.MaxStringLength {
+		return nil, false, ErrLimitExceeded{Limit: "MaxStringLength", Max: int64(limits.MaxStringLength)}
+	}
+
+	entries = make(entrysort, 0, footer.FileCount)
+	_, ok, err = scan(func(name []byte, e vpkentry) {
+		dir, base, ext := splitPath(string(name))
+		entries = append(entries, entrypath{
+			dir:  dir,
+			base: base,
+			ext:  ext,
+
+			vpk: &e,
+		})
+	})
+	if !ok || err != nil {
+		return nil, false, err
+	}
+
+	return entries, true, nil
+}
+
+type bloodlinesFileEntry struct {
+	entryStat
+	o Opener
+	e vpkentry
+}
+
+// Open returns the contents of the file. Bloodlines VPKs do not have
+// checksums, so no verification is done.
+func (e *bloodlinesFileEntry) Open() (io.ReadCloser, error) {
+	return wrapErrors(e.open, e.r, 0x7fff, int64(e.e.Offset))
+}
+
+func (e *bloodlinesFileEntry) open() (io.ReadCloser, error) {
+	f, err := e.o.Main()
+	if err != nil {
+		if f != nil {
+			f.Close()
+		}
+		return nil, err
+	}
+
+	_, err = f.Seek(int64(e.e.Offset), os.SEEK_SET)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return readerCloser{io.LimitReader(f, int64(e.e.Length)), f.Close}, nil
+}
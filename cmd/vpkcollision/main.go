@@ -7,7 +7,6 @@ import (
 	"fmt"
 	"io"
 	"os"
-	"strings"
 
 	"github.com/BenLubar/vpk"
 	"github.com/petar/GoLLRB/llrb"
@@ -48,14 +47,7 @@ func main() {
 	files := llrb.New()
 
 	for _, name := range flag.Args() {
-		var opener vpk.Opener
-		if strings.HasSuffix(name, "_dir.vpk") {
-			opener = vpk.MultiVPK(name[:len(name)-len("_dir.vpk")])
-		} else {
-			opener = vpk.SingleVPK(name)
-		}
-
-		v, err := vpk.Open(opener)
+		v, err := vpk.OpenPath(name, nil)
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "%s: %v\n", name, err)
 			os.Exit(2)
@@ -88,7 +80,7 @@ func main() {
 		for _, v := range f.vpks {
 			hash, err := doHash(v.Entry(f.path))
 			if err != nil {
-				fmt.Fprintf(os.Stderr, "%s: %s: %v\n", reverse[v], f.path, err)
+				fmt.Fprintf(os.Stderr, "%s: %v\n", reverse[v], err)
 				os.Exit(2)
 			}
 			hashes = append(hashes, hash)
@@ -1,9 +1,12 @@
 package main
 
 import (
+	"crypto/rand"
+	"crypto/rsa"
 	"flag"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
 	"path/filepath"
 
@@ -15,21 +18,62 @@ func init() {
 }
 
 func usage() {
-	fmt.Fprintf(os.Stderr, "Usage: vpkcreate [vpkname.vpk] [file1] [file2] [file3]\n")
-	fmt.Fprintf(os.Stderr, "Usage: vpkcreate -M [size] [vpkname] [file1] [file2] [file3]\n\n")
+	fmt.Fprintf(os.Stderr, "Usage: vpkcreate [-V version] [-K key.privatekey.vdf] [vpkname.vpk] [file1] [file2] [file3]\n")
+	fmt.Fprintf(os.Stderr, "Usage: vpkcreate [-V version] [-K key.privatekey.vdf] -M [size] [vpkname] [file1] [file2] [file3]\n")
+	fmt.Fprintf(os.Stderr, "Usage: vpkcreate -keygen [keyname]\n\n")
 	flag.PrintDefaults()
 	os.Exit(2)
 }
 
 func main() {
 	multi := flag.Int64("M", -1, "max size for multipart archives (last file can continue past this size)")
+	version := flag.Uint("V", 1, "VPK version to create (1 or 2)")
+	privateKeyFile := flag.String("K", "", "private key file to sign the VPK with (implies -V 2; cannot be used with other versions)")
+	publicKeyFile := flag.String("k", "", "public key file to check against the private key")
+	keygen := flag.Bool("keygen", false, "generate keyname.publickey.vdf and keyname.privatekey.vdf instead of creating a VPK")
+	keySize := flag.Int("keysize", 2048, "size in bits of keys generated by -keygen")
 
 	flag.Parse()
 
+	if *keygen {
+		if flag.NArg() != 1 {
+			flag.Usage()
+		}
+
+		if err := generateKeyPair(flag.Arg(0), *keySize); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			os.Exit(1)
+		}
+
+		return
+	}
+
 	if flag.NArg() <= 1 {
 		flag.Usage()
 	}
 
+	var privateKey *rsa.PrivateKey
+	if *privateKeyFile != "" {
+		versionSet := false
+		flag.Visit(func(f *flag.Flag) {
+			if f.Name == "V" {
+				versionSet = true
+			}
+		})
+		if versionSet && *version != 2 {
+			fmt.Fprintf(os.Stderr, "vpkcreate: -K cannot be used with -V %d; signed VPKs must be version 2\n\n", *version)
+			flag.Usage()
+		}
+
+		var err error
+		privateKey, err = readPrivateKey(*privateKeyFile, *publicKeyFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			os.Exit(1)
+		}
+		*version = 2
+	}
+
 	var creator vpk.Creator
 	if *multi < 0 {
 		creator = vpk.SingleVPKCreator(flag.Arg(0))
@@ -58,13 +102,64 @@ func main() {
 		}
 	}
 
-	err := vpk.Create(creator, contents, *multi)
+	err := vpk.CreateWithOptions(creator, contents, *multi, &vpk.CreateOptions{
+		Version:    uint32(*version),
+		PrivateKey: privateKey,
+	})
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "%v\n", err)
 		os.Exit(1)
 	}
 }
 
+func generateKeyPair(name string, bits int) error {
+	key, err := rsa.GenerateKey(rand.Reader, bits)
+	if err != nil {
+		return err
+	}
+
+	pub, err := vpk.MarshalPublicKey(&key.PublicKey)
+	if err != nil {
+		return err
+	}
+	priv, err := vpk.MarshalPrivateKey(key)
+	if err != nil {
+		return err
+	}
+
+	if err = ioutil.WriteFile(name+".publickey.vdf", pub, 0644); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(name+".privatekey.vdf", priv, 0600)
+}
+
+func readPrivateKey(privateKeyFile, publicKeyFile string) (*rsa.PrivateKey, error) {
+	b, err := ioutil.ReadFile(privateKeyFile)
+	if err != nil {
+		return nil, err
+	}
+	key, err := vpk.ParsePrivateKey(b)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", privateKeyFile, err)
+	}
+
+	if publicKeyFile != "" {
+		b, err = ioutil.ReadFile(publicKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		pub, err := vpk.ParsePublicKey(b)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", publicKeyFile, err)
+		}
+		if !pub.Equal(&key.PublicKey) {
+			return nil, fmt.Errorf("%s: public key does not match %s", publicKeyFile, privateKeyFile)
+		}
+	}
+
+	return key, nil
+}
+
 type entry string
 
 func (e entry) Rel() string                  { return string(e) }
@@ -1,12 +1,12 @@
 package main
 
 import (
+	"crypto/rsa"
 	"flag"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"os"
-	"strings"
 
 	"github.com/BenLubar/vpk"
 )
@@ -23,6 +23,9 @@ func usage() {
 
 func main() {
 	verbose := flag.Bool("v", false, "print the names of files even if they are valid")
+	publicKeyFile := flag.String("k", "", "public key file (*.publickey.vdf) that signed VPKs must be signed with")
+	lenient := flag.Bool("lenient", false, "report problems with the directory tree and verify the files that could be read")
+	fast := flag.Bool("fast", false, "for version 2 VPKs, only verify the archive MD5 checksums instead of the CRC of every file")
 
 	flag.Parse()
 
@@ -30,38 +33,81 @@ func main() {
 		flag.Usage()
 	}
 
+	var trusted *rsa.PublicKey
+	if *publicKeyFile != "" {
+		b, err := ioutil.ReadFile(*publicKeyFile)
+		if err == nil {
+			trusted, err = vpk.ParsePublicKey(b)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", *publicKeyFile, err)
+			os.Exit(2)
+		}
+	}
+
 	hadError := false
 
 	for _, name := range flag.Args() {
-		var opener vpk.Opener
-		if strings.HasSuffix(name, "_dir.vpk") {
-			opener = vpk.MultiVPK(name[:len(name)-len("_dir.vpk")])
-		} else {
-			opener = vpk.SingleVPK(name)
-		}
-		v, err := vpk.Open(opener)
+		v, err := vpk.OpenPath(name, &vpk.OpenOptions{VerifyMD5: true, Strict: true, Lenient: *lenient})
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "%s: %v\n", name, err)
 			hadError = true
 			continue
 		}
+		for _, p := range v.Problems() {
+			fmt.Printf("%s: %v\n", name, p)
+			hadError = true
+		}
+		err = v.VerifySignature(trusted)
+		if err == vpk.ErrNotSigned && trusted == nil {
+			if *verbose {
+				fmt.Printf("%s: not signed\n", name)
+			}
+		} else if err != nil {
+			fmt.Printf("%s: signature: %v\n", name, err)
+			hadError = true
+		} else if *verbose {
+			fmt.Printf("%s: signature is valid\n", name)
+		}
+		chunks, err := v.VerifyArchiveMD5()
+		if err == vpk.ErrNoArchiveMD5 {
+			// fall back to checking each file
+		} else if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: archive MD5: %v\n", name, err)
+			hadError = true
+		} else {
+			for _, c := range chunks {
+				if c.Err != nil {
+					fmt.Fprintf(os.Stderr, "%s: archive %d offset %d length %d: %v\n", name, c.ArchiveIndex, c.Offset, c.Length, c.Err)
+					hadError = true
+				} else if !c.OK() {
+					fmt.Printf("%s: archive %d offset %d length %d: MD5 mismatch: %x (expected %x)\n", name, c.ArchiveIndex, c.Offset, c.Length, c.Actual, c.Expected)
+					hadError = true
+				} else if *verbose {
+					fmt.Printf("%s: archive %d offset %d length %d is valid\n", name, c.ArchiveIndex, c.Offset, c.Length)
+				}
+			}
+			if *fast {
+				continue
+			}
+		}
 		for _, rel := range v.Paths() {
 			r, err := v.Entry(rel).Open()
 			if err != nil {
-				fmt.Fprintf(os.Stderr, "%s: open %s: %v\n", name, rel, err)
+				fmt.Fprintf(os.Stderr, "%s: %v\n", name, err)
 				hadError = true
 				continue
 			}
 			_, err = io.Copy(ioutil.Discard, r)
 			if err != nil {
-				fmt.Fprintf(os.Stderr, "%s: read %s: %v\n", name, rel, err)
+				fmt.Fprintf(os.Stderr, "%s: %v\n", name, err)
 				hadError = true
 				r.Close()
 				continue
 			}
 			err = r.Close()
 			if err != nil {
-				fmt.Printf("%s: %s: %v\n", name, rel, err)
+				fmt.Printf("%s: %v\n", name, err)
 				hadError = true
 			} else if *verbose {
 				fmt.Printf("%s: %s is valid\n", name, rel)
@@ -0,0 +1,39 @@
+package vpk
+
+import (
+	"bufio"
+)
+
+// countingReader reads from a bufio.Reader and counts the number of bytes that
+// have been consumed.
+type countingReader struct {
+	r *bufio.Reader
+	n int64
+
+	// If maxString is not 0, ReadString returns ErrLimitExceeded for
+	// strings longer than maxString bytes, not including the delimiter.
+	maxString int
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.n += int64(n)
+	return n, err
+}
+
+func (r *countingReader) ReadString(delim byte) (string, error) {
+	var s []byte
+	for {
+		b, err := r.r.ReadSlice(delim)
+		r.n += int64(len(b))
+
+		if r.maxString != 0 && len(s)+len(b) > r.maxString+1 {
+			return "", ErrLimitExceeded{Limit: "MaxStringLength", Max: int64(r.maxString)}
+		}
+
+		s = append(s, b...)
+		if err != bufio.ErrBufferFull {
+			return string(s), err
+		}
+	}
+}
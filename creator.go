@@ -1,7 +1,6 @@
 package vpk
 
 import (
-	"fmt"
 	"io"
 	"os"
 )
@@ -29,18 +28,32 @@ func (o singleVPKCreator) Archive(index int16) (io.WriteCloser, error) {
 	return nil, os.ErrPermission
 }
 
-type multiVPKCreator string
+type multiVPKCreator struct {
+	main    string
+	archive func(index int16) string
+}
 
 // MultiVPKCreator implements a Creator for a multi-part VPK on the OS
 // filesystem. prefix should be the part before "_dir.vpk".
 func MultiVPKCreator(prefix string) Creator {
-	return multiVPKCreator(prefix)
+	return MultiVPKCreatorNamed(prefix+"_dir.vpk", defaultArchiveName(prefix))
+}
+
+// MultiVPKCreatorNamed implements a Creator for a multi-part VPK on the OS
+// filesystem with a custom naming scheme. main is the path of the main VPK
+// file, and archive returns the path of the data-only archive with the given
+// index. See also ArchivePattern.
+func MultiVPKCreatorNamed(main string, archive func(index int16) string) Creator {
+	return multiVPKCreator{main, archive}
 }
 
 func (o multiVPKCreator) Main() (io.WriteCloser, error) {
-	return os.Create(string(o) + "_dir.vpk")
+	return os.Create(o.main)
 }
 
 func (o multiVPKCreator) Archive(index int16) (io.WriteCloser, error) {
-	return os.Create(fmt.Sprintf("%s_%03d.vpk", string(o), index))
+	if index < 0 || index >= 0x7fff {
+		return nil, ErrInvalidArchiveIndex(uint16(index))
+	}
+	return os.Create(o.archive(index))
 }
@@ -3,10 +3,119 @@ package vpk
 import (
 	"errors"
 	"fmt"
+	"io"
+	"strings"
 )
 
+// Error records an error along with the operation and location that caused
+// it. The underlying error can be retrieved with errors.Unwrap, errors.Is,
+// and errors.As.
+type Error struct {
+	// Op is the operation that failed, such as "parse", "open", "read",
+	// "verify", or "create".
+	Op string
+	// Path is the path of the file within the VPK, or as much of it as is
+	// known. It is empty if the error is not related to a specific file.
+	Path string
+	// ArchiveIndex is the index of the archive containing the data, or -1
+	// if it is not known. 0x7fff is the main VPK file.
+	ArchiveIndex int16
+	// Offset is the position in the archive (or the main VPK file) where
+	// the problem was found, or -1 if it is not known.
+	Offset int64
+	// Err is the underlying error.
+	Err error
+}
+
+func (err *Error) Error() string {
+	var buf strings.Builder
+	buf.WriteString("vpk: ")
+	buf.WriteString(err.Op)
+	if err.Path != "" {
+		buf.WriteString(" ")
+		buf.WriteString(err.Path)
+	}
+	if err.ArchiveIndex == 0x7fff {
+		buf.WriteString(" (main file")
+	} else if err.ArchiveIndex >= 0 {
+		fmt.Fprintf(&buf, " (archive %d", err.ArchiveIndex)
+	}
+	if err.Offset >= 0 {
+		if err.ArchiveIndex >= 0 {
+			buf.WriteString(", ")
+		} else {
+			buf.WriteString(" (")
+		}
+		fmt.Fprintf(&buf, "offset %d", err.Offset)
+	}
+	if err.ArchiveIndex >= 0 || err.Offset >= 0 {
+		buf.WriteString(")")
+	}
+	buf.WriteString(": ")
+	buf.WriteString(strings.TrimPrefix(err.Err.Error(), "vpk: "))
+	return buf.String()
+}
+
+func (err *Error) Unwrap() error {
+	return err.Err
+}
+
+// wrapErrors calls open and wraps any error it returns, as well as any errors
+// returned by the io.ReadCloser, in *Error with the given location.
+func wrapErrors(open func() (io.ReadCloser, error), path string, archiveIndex int16, offset int64) (io.ReadCloser, error) {
+	loc := Error{
+		Path:         path,
+		ArchiveIndex: archiveIndex,
+		Offset:       offset,
+	}
+
+	r, err := open()
+	if err != nil {
+		return nil, loc.wrap("open", err)
+	}
+
+	return &errorReader{r, loc}, nil
+}
+
+// wrap returns a copy of err with the given operation and underlying error.
+func (err Error) wrap(op string, inner error) error {
+	err.Op = op
+	err.Err = inner
+	return &err
+}
+
+type errorReader struct {
+	r   io.ReadCloser
+	loc Error
+}
+
+func (r *errorReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if err != nil && err != io.EOF {
+		err = r.loc.wrap("read", err)
+	}
+	return n, err
+}
+
+func (r *errorReader) Close() error {
+	err := r.r.Close()
+	if _, ok := err.(ErrCRCMismatch); ok {
+		return r.loc.wrap("verify", err)
+	}
+	if err != nil {
+		return r.loc.wrap("close", err)
+	}
+	return nil
+}
+
+// ErrCorrupt matches every error that indicates corrupt data when used with
+// errors.Is, including checksum mismatches and invalid directory entries.
+var ErrCorrupt = errors.New("vpk: corrupt data")
+
 var ErrInvalidMagic = errors.New("vpk: invalid magic number")
 
+var ErrInvalidHeader = errors.New("vpk: invalid header")
+
 type ErrUnsupportedVersion uint32
 
 func (err ErrUnsupportedVersion) Error() string {
@@ -21,12 +130,119 @@ func (err ErrCRCMismatch) Error() string {
 	return fmt.Sprintf("vpk: CRC mismatch: %08x (expected %08x)", err.Actual, err.Expected)
 }
 
+func (err ErrCRCMismatch) Is(target error) bool {
+	return target == ErrCorrupt
+}
+
+// MD5Checksum identifies one of the checksums in the other MD5 section of a
+// version 2 VPK.
+type MD5Checksum int
+
+const (
+	// TreeMD5 is the checksum of the directory tree.
+	TreeMD5 MD5Checksum = iota
+	// ArchiveMD5SectionMD5 is the checksum of the archive MD5 section.
+	ArchiveMD5SectionMD5
+	// WholeFileMD5 is the checksum of the main VPK file up to the whole
+	// file checksum.
+	WholeFileMD5
+)
+
+func (c MD5Checksum) String() string {
+	switch c {
+	case TreeMD5:
+		return "tree"
+	case ArchiveMD5SectionMD5:
+		return "archive MD5 section"
+	case WholeFileMD5:
+		return "whole file"
+	}
+	return fmt.Sprintf("MD5Checksum(%d)", int(c))
+}
+
+type ErrMD5Mismatch struct {
+	Checksum         MD5Checksum
+	Actual, Expected [16]byte
+}
+
+func (err ErrMD5Mismatch) Error() string {
+	return fmt.Sprintf("vpk: %v MD5 mismatch: %x (expected %x)", err.Checksum, err.Actual, err.Expected)
+}
+
+func (err ErrMD5Mismatch) Is(target error) bool {
+	return target == ErrCorrupt
+}
+
+type ErrTreeLengthMismatch struct {
+	Actual   int64
+	Expected uint32
+}
+
+func (err ErrTreeLengthMismatch) Error() string {
+	return fmt.Sprintf("vpk: directory tree is %d bytes long (header says %d)", err.Actual, err.Expected)
+}
+
+func (err ErrTreeLengthMismatch) Is(target error) bool {
+	return target == ErrCorrupt
+}
+
+type ErrLimitExceeded struct {
+	// The name of the field in Limits that was exceeded.
+	Limit string
+	Max   int64
+}
+
+func (err ErrLimitExceeded) Error() string {
+	return fmt.Sprintf("vpk: limit exceeded: %s (maximum %d)", err.Limit, err.Max)
+}
+
 type ErrInvalidEntry struct {
 	Dir, Base, Ext string
 }
 
 func (err ErrInvalidEntry) Error() string {
-	return fmt.Sprintf("vpk: entry for %s/%s.%s is corrupt", err.Dir, err.Base, err.Ext)
+	return fmt.Sprintf("vpk: entry for %s is corrupt", joinPath(err.Dir, err.Base, err.Ext))
+}
+
+func (err ErrInvalidEntry) Is(target error) bool {
+	return target == ErrCorrupt
 }
 
 var ErrFileTooBig = errors.New("vpk: file too big")
+
+var ErrUnsupportedCompression = errors.New("vpk: unsupported compression")
+
+var ErrNoArchiveMD5 = errors.New("vpk: no archive MD5 section")
+
+var ErrNotSigned = errors.New("vpk: not signed")
+
+var ErrSigningRequiresVersion2 = errors.New("vpk: signing requires VPK version 2")
+
+var ErrInvalidSignatureSection = errors.New("vpk: invalid signature section")
+
+var ErrInvalidSignature = errors.New("vpk: invalid signature")
+
+var ErrUntrustedKey = errors.New("vpk: signed with untrusted key")
+
+var ErrInvalidKeyFile = errors.New("vpk: invalid key file")
+
+var ErrEncryptedKey = errors.New("vpk: encrypted private keys are not supported")
+
+// ErrInvalidPath is returned by Create if a path refers to something outside
+// of the VPK, such as "../file.txt", or to the root directory.
+var ErrInvalidPath = errors.New("vpk: invalid path")
+
+// ErrDuplicatePath is returned by Create if two paths refer to the same file
+// after being normalized the way VPK.Entry normalizes them. Its value is the
+// first of the two paths.
+type ErrDuplicatePath string
+
+func (err ErrDuplicatePath) Error() string {
+	return fmt.Sprintf("vpk: same file as %q", string(err))
+}
+
+type ErrInvalidArchiveIndex uint32
+
+func (err ErrInvalidArchiveIndex) Error() string {
+	return fmt.Sprintf("vpk: invalid archive index: %d", uint32(err))
+}
@@ -0,0 +1,317 @@
+package vpk
+
+import (
+	"hash"
+	"hash/crc32"
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+)
+
+var (
+	_ fs.ReadDirFS  = vpkFS{}
+	_ fs.StatFS     = vpkFS{}
+	_ fs.ReadFileFS = vpkFS{}
+)
+
+// FS returns an fs.FS containing the files in the VPK. The returned value also
+// implements fs.ReadDirFS, fs.StatFS, and fs.ReadFileFS. Paths are matched
+// case-insensitively. Files implement io.Seeker and io.ReaderAt, so the
+// returned value can be served using http.FS. The Close method of each file
+// verifies its CRC if the whole file was read in order, and the Sys method of
+// each file's fs.FileInfo returns a *EntryInfo.
+func (v *VPK) FS() fs.FS {
+	return vpkFS{v}
+}
+
+type vpkFS struct {
+	v *VPK
+}
+
+// dirNode is a directory in the index built by VPK.dirIndex.
+type dirNode struct {
+	// The contents of the directory, sorted by name.
+	children []dirChild
+}
+
+type dirChild struct {
+	// The name of the file or directory, without the parent directory.
+	name string
+	// The index of the file in VPK.entries, or -1 for a directory.
+	entry int
+}
+
+// dirIndex returns the directory structure of the VPK, building it the first
+// time it is needed. The keys are directory paths without a trailing slash;
+// the root directory is "".
+func (v *VPK) dirIndex() map[string]*dirNode {
+	v.dirsOnce.Do(func() {
+		dirs := map[string]*dirNode{"": {}}
+
+		var add func(dir string) *dirNode
+		add = func(dir string) *dirNode {
+			if n, ok := dirs[dir]; ok {
+				return n
+			}
+
+			n := &dirNode{}
+			dirs[dir] = n
+
+			parent, name := "", dir
+			if i := strings.LastIndexByte(dir, '/'); i != -1 {
+				parent, name = dir[:i], dir[i+1:]
+			}
+			p := add(parent)
+			p.children = append(p.children, dirChild{name, -1})
+
+			return n
+		}
+
+		for i, e := range v.entries {
+			dir := e.dir
+			if dir == " " {
+				dir = ""
+			}
+			n := add(dir)
+			n.children = append(n.children, dirChild{joinPath(" ", e.base, e.ext), i})
+		}
+
+		for _, n := range dirs {
+			sort.Slice(n.children, func(i, j int) bool {
+				return n.children[i].name < n.children[j].name
+			})
+		}
+
+		v.dirs = dirs
+	})
+
+	return v.dirs
+}
+
+// lookupDir returns the directory with the given path, or nil if there is no
+// such directory. The path is normalized the same way as in VPK.Entry, so "."
+// and "" are the root directory.
+func (v *VPK) lookupDir(name string) *dirNode {
+	name, ok := cleanPath(name)
+	if !ok {
+		return nil
+	}
+	return v.dirIndex()[name]
+}
+
+// dirInfo returns an fs.FileInfo for the directory with the given path.
+func (v *VPK) dirInfo(name string) fs.FileInfo {
+	return &httpFileInfo{
+		name:    path.Base(name),
+		isDir:   true,
+		modTime: v.modtime,
+	}
+}
+
+// childInfo returns an fs.FileInfo for a child of the directory named dir.
+func (v *VPK) childInfo(dir string, c dirChild) fs.FileInfo {
+	rel := path.Join(dir, c.name)
+	if c.entry == -1 {
+		return v.dirInfo(rel)
+	}
+
+	fi, _ := v.entry(rel, &v.entries[c.entry]).(StatEntry).Stat()
+	return fi
+}
+
+// entry is like VPK.Entry, but io/fs does not allow backslashes as path
+// separators, so paths containing them never match anything.
+func (f vpkFS) entry(name string) Entry {
+	if strings.ContainsRune(name, '\\') {
+		return nil
+	}
+	return f.v.Entry(name)
+}
+
+// dir is like VPK.lookupDir, but does not allow backslashes, like entry.
+func (f vpkFS) dir(name string) *dirNode {
+	if strings.ContainsRune(name, '\\') {
+		return nil
+	}
+	return f.v.lookupDir(name)
+}
+
+func (f vpkFS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+
+	if ent := f.entry(name); ent != nil {
+		fi, err := ent.(StatEntry).Stat()
+		if err != nil {
+			return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+		}
+		r, err := ent.(RandomAccessEntry).OpenReaderAt()
+		if err != nil {
+			return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+		}
+		return &fsFile{EntryReader: r, info: fi, hash: crc32.NewIEEE()}, nil
+	}
+
+	if d := f.dir(name); d != nil {
+		return &fsDir{f.v, name, d.children}, nil
+	}
+
+	return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+}
+
+func (f vpkFS) Stat(name string) (fs.FileInfo, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrInvalid}
+	}
+
+	if ent := f.entry(name); ent != nil {
+		return ent.(StatEntry).Stat()
+	}
+
+	if f.dir(name) != nil {
+		return f.v.dirInfo(name), nil
+	}
+
+	return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrNotExist}
+}
+
+func (f vpkFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrInvalid}
+	}
+
+	d := f.dir(name)
+	if d == nil {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrNotExist}
+	}
+
+	entries := make([]fs.DirEntry, len(d.children))
+	for i, c := range d.children {
+		entries[i] = fs.FileInfoToDirEntry(f.v.childInfo(name, c))
+	}
+
+	return entries, nil
+}
+
+func (f vpkFS) ReadFile(name string) ([]byte, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "readfile", Path: name, Err: fs.ErrInvalid}
+	}
+
+	ent := f.entry(name)
+	if ent == nil {
+		return nil, &fs.PathError{Op: "readfile", Path: name, Err: fs.ErrNotExist}
+	}
+
+	r, err := ent.Open()
+	if err != nil {
+		return nil, &fs.PathError{Op: "readfile", Path: name, Err: err}
+	}
+
+	b := make([]byte, ent.(StatEntry).Info().Size)
+	_, err = io.ReadFull(r, b)
+	if err == nil {
+		// make sure there is no more data so the CRC is checked
+		// against the whole file.
+		var extra [1]byte
+		if n, _ := r.Read(extra[:]); n != 0 {
+			err = ErrFileTooBig
+		}
+	}
+	if err != nil {
+		r.Close()
+		return nil, &fs.PathError{Op: "readfile", Path: name, Err: err}
+	}
+
+	if err = r.Close(); err != nil {
+		return nil, &fs.PathError{Op: "readfile", Path: name, Err: err}
+	}
+
+	return b, nil
+}
+
+// fsFile is a file opened by vpkFS. It implements io.Seeker and io.ReaderAt,
+// so it can be used with http.FS. If the whole file is read in order from the
+// beginning using Read, Close verifies its CRC.
+type fsFile struct {
+	*EntryReader
+	info fs.FileInfo
+
+	// pos is the position used by Read and Seek, and hashed is the number
+	// of bytes from the start of the file that have been added to hash.
+	pos    int64
+	hashed int64
+	hash   hash.Hash32
+}
+
+func (f *fsFile) Stat() (fs.FileInfo, error) {
+	return f.info, nil
+}
+
+func (f *fsFile) Read(p []byte) (int, error) {
+	n, err := f.EntryReader.Read(p)
+	if f.pos == f.hashed {
+		f.hash.Write(p[:n])
+		f.hashed += int64(n)
+	}
+	f.pos += int64(n)
+	return n, err
+}
+
+func (f *fsFile) Seek(offset int64, whence int) (int64, error) {
+	pos, err := f.EntryReader.Seek(offset, whence)
+	if err == nil {
+		f.pos = pos
+	}
+	return pos, err
+}
+
+func (f *fsFile) Close() error {
+	err := f.EntryReader.Close()
+	if f.hashed == f.Size() {
+		if e := f.checkCRC(f.hash.Sum32()); err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+type fsDir struct {
+	v        *VPK
+	name     string
+	children []dirChild
+}
+
+func (d *fsDir) Stat() (fs.FileInfo, error) {
+	return d.v.dirInfo(d.name), nil
+}
+
+func (d *fsDir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.name, Err: fs.ErrInvalid}
+}
+
+func (d *fsDir) Close() error {
+	return nil
+}
+
+func (d *fsDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	children := d.children
+	if n > 0 && len(children) > n {
+		children = children[:n]
+	}
+	d.children = d.children[len(children):]
+
+	if n > 0 && len(children) == 0 {
+		return nil, io.EOF
+	}
+
+	entries := make([]fs.DirEntry, len(children))
+	for i, c := range children {
+		entries[i] = fs.FileInfoToDirEntry(d.v.childInfo(d.name, c))
+	}
+
+	return entries, nil
+}
@@ -0,0 +1,113 @@
+package vpk
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"testing/fstest"
+)
+
+func TestFS(t *testing.T) {
+	tv := multiTestVPK(t)
+	entries := testEntries()
+	tv.create(t, entries, nil)
+
+	v, err := Open(tv.opener)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Rel())
+	}
+
+	if err = fstest.TestFS(v.FS(), names...); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = v.FS().Open("missing.txt"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("expected fs.ErrNotExist, got %v", err)
+	}
+}
+
+func TestFSHTTP(t *testing.T) {
+	tv := multiTestVPK(t)
+	tv.create(t, testEntries(), nil)
+
+	v, err := Open(tv.opener)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := httptest.NewServer(http.FileServer(http.FS(v.FS())))
+	defer srv.Close()
+
+	req, err := http.NewRequest("GET", srv.URL+"/dir1/sub/file4.txt", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Range", "bytes=10-19")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if resp.StatusCode != http.StatusPartialContent || !bytes.Equal(b, bytes.Repeat([]byte{4}, 10)) {
+		t.Errorf("unexpected response: %s %q", resp.Status, b)
+	}
+}
+
+func TestFSCRC(t *testing.T) {
+	tv := singleTestVPK(t)
+	tv.create(t, []Entry{testEntry{"a.txt", []byte("hello, world")}}, nil)
+
+	info, err := os.Stat(tv.main)
+	if err != nil {
+		t.Fatal(err)
+	}
+	corrupt(t, tv.main, info.Size()-1)
+
+	v, err := Open(tv.opener)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := v.FS().Open("a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = ioutil.ReadAll(f); err != nil {
+		t.Fatal(err)
+	}
+	if err = f.Close(); !errors.Is(err, ErrCorrupt) {
+		t.Errorf("expected a CRC mismatch, got %v", err)
+	}
+
+	// a partial read can't be checked.
+	f, err = v.FS().Open("a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = f.(io.Seeker).Seek(5, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = ioutil.ReadAll(f); err != nil {
+		t.Fatal(err)
+	}
+	if err = f.Close(); err != nil {
+		t.Errorf("expected no error, got %v", err)
+	}
+}
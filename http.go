@@ -1,9 +1,7 @@
 package vpk
 
 import (
-	"bytes"
 	"io"
-	"io/ioutil"
 	"net/http"
 	"os"
 	"path"
@@ -14,37 +12,70 @@ import (
 var _ http.FileSystem = (*VPK)(nil)
 
 func (vpk *VPK) Open(rel string) (http.File, error) {
+	return vpk.openHTTP(rel, false)
+}
+
+// VerifyingFileSystem returns an http.FileSystem that is the same as the VPK,
+// except that the CRC of each file is checked when it is opened. If the CRC
+// does not match, Open returns an error. This reads the whole file from disk
+// each time it is opened, but does not keep it in memory.
+func (vpk *VPK) VerifyingFileSystem() http.FileSystem {
+	return verifyingFileSystem{vpk}
+}
+
+type verifyingFileSystem struct {
+	vpk *VPK
+}
+
+func (fs verifyingFileSystem) Open(rel string) (http.File, error) {
+	return fs.vpk.openHTTP(rel, true)
+}
+
+// openHTTP opens the file or directory named rel. Like http.Dir, rel is
+// cleaned and may start with a slash. If there is no such file or directory,
+// the error satisfies os.IsNotExist.
+func (vpk *VPK) openHTTP(rel string, verify bool) (http.File, error) {
+	rel = strings.TrimPrefix(path.Clean("/"+rel), "/")
+
 	if ent := vpk.Entry(rel); ent != nil {
-		return vpk.openFile(ent)
+		return vpk.openFile(ent, verify)
 	}
-	return vpk.openDir(rel), nil
-}
 
-func (vpk *VPK) openFile(ent Entry) (http.File, error) {
-	r, err := ent.Open()
-	if err != nil {
-		return nil, err
+	if vpk.lookupDir(rel) != nil {
+		return vpk.openDir(rel), nil
 	}
-	b, err := ioutil.ReadAll(r)
+
+	return nil, &os.PathError{Op: "open", Path: rel, Err: os.ErrNotExist}
+}
+
+// openFile returns an http.File that reads directly from the archive
+// containing ent, so it can be served in pieces without reading the whole
+// file into memory.
+func (vpk *VPK) openFile(ent Entry, verify bool) (http.File, error) {
+	r, err := ent.(RandomAccessEntry).OpenReaderAt()
 	if err != nil {
-		r.Close()
 		return nil, err
 	}
-	err = r.Close()
-	if err != nil {
-		return nil, err
+
+	if verify {
+		if err = r.VerifyCRC(); err != nil {
+			r.Close()
+			return nil, err
+		}
 	}
 
-	return &httpFile{bytes.NewReader(b), httpFileInfo{
+	info := ent.(StatEntry).Info()
+	return &httpFile{r, httpFileInfo{
 		name:    path.Base(ent.Rel()),
 		isDir:   false,
 		modTime: vpk.modtime,
-		size:    int64(len(b)),
+		size:    r.Size(),
+		sys:     &info,
 	}}, nil
 }
 
 type httpFile struct {
-	*bytes.Reader
+	*EntryReader
 	info httpFileInfo
 }
 
@@ -52,10 +83,6 @@ func (f *httpFile) Stat() (os.FileInfo, error) {
 	return &f.info, nil
 }
 
-func (f *httpFile) Close() error {
-	return nil
-}
-
 func (f *httpFile) Readdir(n int) ([]os.FileInfo, error) {
 	return nil, os.ErrInvalid
 }
@@ -116,59 +143,14 @@ func (f *httpDir) Readdir(n int) ([]os.FileInfo, error) {
 }
 
 func (d *httpDir) readdir() ([]os.FileInfo, error) {
-	var dirs []string
-	var files []os.FileInfo
-
-	dir := d.rel
-	if dir == "" {
-		dir = " "
-	}
-	prefix := d.rel + "/"
-	if prefix == "/" {
-		prefix = ""
-	}
-	for _, e := range d.vpk.entries {
-		if e.dir == d.rel {
-			rel := prefix
-			if e.base != " " {
-				rel += e.base
-			}
-			if e.ext != " " {
-				rel += "." + e.ext
-			}
-			f, err := d.vpk.openFile(&vpkFileEntry{d.vpk.opener, d.vpk.treeLength, rel, *e.vpk, e.pre})
-			if err != nil {
-				return nil, err
-			}
-			fi, err := f.Stat()
-			if err != nil {
-				return nil, err
-			}
-			files = append(files, fi)
-		} else if strings.HasPrefix(e.dir, prefix) {
-			dir := e.dir
-			if i := strings.Index(dir[len(prefix):], "/"); i != -1 {
-				dir = dir[:len(prefix)+i]
-			}
-			found := false
-			for _, s := range dirs {
-				if s == dir {
-					found = true
-					break
-				}
-			}
-			if !found {
-				dirs = append(dirs, dir)
-			}
-		}
+	dir := d.vpk.lookupDir(d.rel)
+	if dir == nil {
+		return nil, &os.PathError{Op: "readdir", Path: d.rel, Err: os.ErrNotExist}
 	}
 
-	for _, dir := range dirs {
-		fi, err := d.vpk.openDir(dir).Stat()
-		if err != nil {
-			return nil, err
-		}
-		files = append(files, fi)
+	files := make([]os.FileInfo, len(dir.children))
+	for i, c := range dir.children {
+		files[i] = d.vpk.childInfo(d.rel, c)
 	}
 
 	return files, nil
@@ -179,6 +161,7 @@ type httpFileInfo struct {
 	isDir   bool
 	modTime time.Time
 	size    int64
+	sys     interface{}
 }
 
 func (fi *httpFileInfo) Name() string {
@@ -206,5 +189,5 @@ func (fi *httpFileInfo) Mode() os.FileMode {
 }
 
 func (fi *httpFileInfo) Sys() interface{} {
-	return nil
+	return fi.sys
 }
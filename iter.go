@@ -0,0 +1,135 @@
+//go:build go1.23
+
+package vpk
+
+import (
+	"iter"
+	"sort"
+)
+
+// Order is the order in which VPK.Entries and VPK.Files visit files.
+type Order int
+
+const (
+	// TreeOrder visits files in the order they are sorted in the directory
+	// tree, which is the same order as VPK.Paths: by extension, then by
+	// directory, then by name.
+	TreeOrder Order = iota
+	// DiskOrder visits files in the order they are stored on disk: by
+	// archive index, then by offset. Files stored in the main VPK file
+	// come after the files stored in numbered archives.
+	DiskOrder
+)
+
+// IndexEntry is a file in the directory tree of a VPK, as visited by
+// VPK.Entries and VPK.Files. Its methods return strings that are already in
+// memory, except for Rel, which has to join them together.
+type IndexEntry struct {
+	v *VPK
+	e *entrypath
+}
+
+// Dir returns the directory containing the file, or "" for the root directory.
+func (e IndexEntry) Dir() string {
+	if e.e.dir == " " {
+		return ""
+	}
+	return e.e.dir
+}
+
+// Base returns the name of the file without the directory or extension.
+func (e IndexEntry) Base() string {
+	if e.e.base == " " {
+		return ""
+	}
+	return e.e.base
+}
+
+// Ext returns the extension of the file without the leading dot, or "" if the
+// file does not have an extension.
+func (e IndexEntry) Ext() string {
+	if e.e.ext == " " {
+		return ""
+	}
+	return e.e.ext
+}
+
+// Rel returns the relative path of the file, as returned by VPK.Paths.
+func (e IndexEntry) Rel() string {
+	return joinPath(e.e.dir, e.e.base, e.e.ext)
+}
+
+// Info returns the storage details of the file.
+func (e IndexEntry) Info() EntryInfo {
+	return e.v.info(e.e)
+}
+
+// Entry returns the file as an Entry, which is the same as calling VPK.Entry
+// with the file's path.
+func (e IndexEntry) Entry() Entry {
+	return e.v.entry(e.Rel(), e.e)
+}
+
+// Files returns an iterator over the files in the VPK in the given order.
+func (v *VPK) Files(order Order) iter.Seq[IndexEntry] {
+	return func(yield func(IndexEntry) bool) {
+		for i := range v.order(order) {
+			if !yield(IndexEntry{v, &v.entries[i]}) {
+				return
+			}
+		}
+	}
+}
+
+// Entries returns an iterator over the files in the VPK and their storage
+// details in the given order.
+func (v *VPK) Entries(order Order) iter.Seq2[IndexEntry, EntryInfo] {
+	return func(yield func(IndexEntry, EntryInfo) bool) {
+		for i := range v.order(order) {
+			e := &v.entries[i]
+			if !yield(IndexEntry{v, e}, v.info(e)) {
+				return
+			}
+		}
+	}
+}
+
+// order returns an iterator over the indices of v.entries in the given order.
+func (v *VPK) order(order Order) iter.Seq[int] {
+	return func(yield func(int) bool) {
+		if order != DiskOrder {
+			for i := range v.entries {
+				if !yield(i) {
+					return
+				}
+			}
+			return
+		}
+
+		type key struct {
+			archive uint16
+			offset  int64
+			index   int
+		}
+		keys := make([]key, len(v.entries))
+		for i := range v.entries {
+			info := v.info(&v.entries[i])
+			keys[i] = key{uint16(info.ArchiveIndex), info.Offset, i}
+		}
+		sort.Slice(keys, func(i, j int) bool {
+			if keys[i].archive != keys[j].archive {
+				return keys[i].archive < keys[j].archive
+			}
+			if keys[i].offset != keys[j].offset {
+				return keys[i].offset < keys[j].offset
+			}
+			return keys[i].index < keys[j].index
+		})
+
+		for _, k := range keys {
+			if !yield(k.index) {
+				return
+			}
+		}
+	}
+}
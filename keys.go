@@ -0,0 +1,229 @@
+package vpk
+
+import (
+	"bytes"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// The functions in this file read and write RSA keys in the KeyValues format
+// used by Valve's vpk tool (*.publickey.vdf and *.privatekey.vdf). Keys are
+// stored as hex-encoded DER: PKIX for public keys and PKCS #8 for private
+// keys.
+
+// keyValue is a node in a parsed KeyValues document. If block is true, the key
+// was followed by a block and children holds its contents. Otherwise, the key
+// was followed by a string, which is stored in value.
+type keyValue struct {
+	key      string
+	value    string
+	children []keyValue
+	block    bool
+}
+
+func (kv *keyValue) child(key string) *keyValue {
+	for i := range kv.children {
+		if strings.EqualFold(kv.children[i].key, key) {
+			return &kv.children[i]
+		}
+	}
+	return nil
+}
+
+// parseKeyValues parses the subset of the KeyValues format used by key files:
+// quoted or unquoted strings, nested blocks, and // comments.
+func parseKeyValues(b []byte) (*keyValue, error) {
+	var tokens []string
+	var quoted []bool
+	for i := 0; i < len(b); {
+		switch c := b[i]; {
+		case c == ' ' || c == '\t' || c == '\r' || c == '\n':
+			i++
+		case c == '/' && i+1 < len(b) && b[i+1] == '/':
+			for i < len(b) && b[i] != '\n' {
+				i++
+			}
+		case c == '{' || c == '}':
+			tokens = append(tokens, string(c))
+			quoted = append(quoted, false)
+			i++
+		case c == '"':
+			end := bytes.IndexByte(b[i+1:], '"')
+			if end == -1 {
+				return nil, ErrInvalidKeyFile
+			}
+			tokens = append(tokens, string(b[i+1:i+1+end]))
+			quoted = append(quoted, true)
+			i += end + 2
+		default:
+			start := i
+			for i < len(b) && !strings.ContainsRune(" \t\r\n{}\"", rune(b[i])) {
+				i++
+			}
+			tokens = append(tokens, string(b[start:i]))
+			quoted = append(quoted, false)
+		}
+	}
+
+	isBrace := func(i int, brace string) bool {
+		return i < len(tokens) && !quoted[i] && tokens[i] == brace
+	}
+
+	var parse func(i int, kv *keyValue) (int, error)
+	parse = func(i int, kv *keyValue) (int, error) {
+		for !isBrace(i, "}") {
+			if i+1 >= len(tokens) || isBrace(i, "{") {
+				return 0, ErrInvalidKeyFile
+			}
+			child := keyValue{key: tokens[i]}
+			if isBrace(i+1, "{") {
+				child.block = true
+				var err error
+				i, err = parse(i+2, &child)
+				if err != nil {
+					return 0, err
+				}
+			} else if isBrace(i+1, "}") {
+				return 0, ErrInvalidKeyFile
+			} else {
+				child.value = tokens[i+1]
+				i += 2
+			}
+			kv.children = append(kv.children, child)
+		}
+		return i + 1, nil
+	}
+
+	if len(tokens) < 3 || !isBrace(1, "{") {
+		return nil, ErrInvalidKeyFile
+	}
+	root := &keyValue{key: tokens[0], block: true}
+	i, err := parse(2, root)
+	if err != nil {
+		return nil, err
+	}
+	if i != len(tokens) {
+		return nil, ErrInvalidKeyFile
+	}
+
+	return root, nil
+}
+
+// keyBytes returns the decoded hex value of the given key in kv, after
+// checking that kv describes an RSA key.
+func keyBytes(kv *keyValue, key string) ([]byte, error) {
+	if t := kv.child("type"); t == nil || t.block || !strings.EqualFold(t.value, "rsa") {
+		return nil, ErrInvalidKeyFile
+	}
+	v := kv.child(key)
+	if v == nil || v.block {
+		return nil, ErrInvalidKeyFile
+	}
+	return hex.DecodeString(v.value)
+}
+
+// ParsePublicKey parses an RSA public key from a *.publickey.vdf file. The
+// public key inside a *.privatekey.vdf file is also accepted.
+func ParsePublicKey(b []byte) (*rsa.PublicKey, error) {
+	root, err := parseKeyValues(b)
+	if err != nil {
+		return nil, err
+	}
+
+	kv := root
+	if strings.EqualFold(root.key, "private_key") {
+		kv = root.child("public_key")
+	} else if !strings.EqualFold(root.key, "public_key") {
+		kv = nil
+	}
+	if kv == nil || !kv.block {
+		return nil, ErrInvalidKeyFile
+	}
+
+	der, err := keyBytes(kv, "rsa_public_key")
+	if err != nil {
+		return nil, err
+	}
+
+	return parsePublicKey(der)
+}
+
+// ParsePrivateKey parses an RSA private key from a *.privatekey.vdf file.
+// Encrypted private keys are not supported.
+func ParsePrivateKey(b []byte) (*rsa.PrivateKey, error) {
+	root, err := parseKeyValues(b)
+	if err != nil {
+		return nil, err
+	}
+
+	if !strings.EqualFold(root.key, "private_key") {
+		return nil, ErrInvalidKeyFile
+	}
+	if enc := root.child("private_key_encrypted"); enc != nil && enc.value != "0" {
+		return nil, ErrEncryptedKey
+	}
+
+	der, err := keyBytes(root, "rsa_private_key")
+	if err != nil {
+		return nil, err
+	}
+
+	if key, err := x509.ParsePKCS1PrivateKey(der); err == nil {
+		return key, nil
+	}
+	key, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return nil, err
+	}
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, ErrInvalidKeyFile
+	}
+
+	return rsaKey, nil
+}
+
+// MarshalPublicKey encodes key in the format of a *.publickey.vdf file.
+func MarshalPublicKey(key *rsa.PublicKey) ([]byte, error) {
+	der, err := x509.MarshalPKIXPublicKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "\"public_key\"\n{\n")
+	fmt.Fprintf(&buf, "\t\"type\"\t\t\"rsa\"\n")
+	fmt.Fprintf(&buf, "\t\"rsa_public_key\"\t\t\"%X\"\n", der)
+	fmt.Fprintf(&buf, "}\n")
+
+	return buf.Bytes(), nil
+}
+
+// MarshalPrivateKey encodes key in the format of an unencrypted
+// *.privatekey.vdf file.
+func MarshalPrivateKey(key *rsa.PrivateKey) ([]byte, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	pubDER, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "\"private_key\"\n{\n")
+	fmt.Fprintf(&buf, "\t\"type\"\t\t\"rsa\"\n")
+	fmt.Fprintf(&buf, "\t\"rsa_private_key\"\t\t\"%X\"\n", der)
+	fmt.Fprintf(&buf, "\t\"private_key_encrypted\"\t\t\"0\"\n")
+	fmt.Fprintf(&buf, "\t\"public_key\"\n\t{\n")
+	fmt.Fprintf(&buf, "\t\t\"type\"\t\t\"rsa\"\n")
+	fmt.Fprintf(&buf, "\t\t\"rsa_public_key\"\t\t\"%X\"\n", pubDER)
+	fmt.Fprintf(&buf, "\t}\n")
+	fmt.Fprintf(&buf, "}\n")
+
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,97 @@
+package vpk
+
+import (
+	"sort"
+)
+
+// Header holds the fields of a VPK header. Fields that are not present in
+// the VPK's version are 0.
+type Header struct {
+	// The VPK version, or 0 if the VPK does not have a header.
+	Version uint32
+	// The number of bytes in the directory tree.
+	TreeLength uint32
+	// The number of bytes of file data stored in the main VPK file after
+	// the directory tree. Version 2 only.
+	FileDataSectionSize uint32
+	// The number of bytes in the archive MD5 section. Version 2 only.
+	ArchiveMD5SectionSize uint32
+	// The number of bytes in the other MD5 section. Version 2 only.
+	OtherMD5SectionSize uint32
+	// The number of bytes in the signature section. Version 2 only.
+	SignatureSectionSize uint32
+}
+
+// Header returns the fields of the VPK header. For headerless VPKs, the tree
+// length is the number of bytes read while parsing the directory tree. For
+// Bloodlines VPKs, every field is 0.
+func (v *VPK) Header() Header {
+	return Header{
+		Version:               v.version,
+		TreeLength:            v.treeLength,
+		FileDataSectionSize:   v.header2.FileDataSectionSize,
+		ArchiveMD5SectionSize: v.header2.ArchiveMD5SectionSize,
+		OtherMD5SectionSize:   v.header2.OtherMD5SectionSize,
+		SignatureSectionSize:  v.header2.SignatureSectionSize,
+	}
+}
+
+// HeaderLength returns the number of bytes in the VPK header, which comes
+// before the directory tree in the main VPK file.
+func (v *VPK) HeaderLength() int64 {
+	return v.headerLength()
+}
+
+// Layout summarizes where the files in a VPK are stored.
+type Layout struct {
+	// The number of files in the VPK.
+	Entries int
+	// The indices of the data-only archives referenced by the directory
+	// tree, in increasing order. The main VPK file (0x7fff) is not
+	// included.
+	Archives []int16
+	// The number of bytes of file data stored in each archive, by index.
+	// This is the sum of the lengths of the files stored in the archive,
+	// so it does not include gaps between files.
+	ArchiveBytes map[int16]int64
+	// The number of bytes of file data stored in the main VPK file after
+	// the directory tree.
+	EmbeddedBytes int64
+	// The total number of bytes of preloaded data stored in the directory
+	// tree.
+	PreloadBytes int64
+	// The total size of the files in the VPK.
+	TotalSize int64
+}
+
+// Layout computes a summary of where the files in the VPK are stored. It reads
+// only the directory tree, which is already in memory.
+func (v *VPK) Layout() Layout {
+	l := Layout{
+		Entries:      len(v.entries),
+		ArchiveBytes: make(map[int16]int64),
+	}
+
+	for i := range v.entries {
+		info := v.info(&v.entries[i])
+
+		l.PreloadBytes += int64(info.PreloadBytes)
+		l.TotalSize += info.Size
+
+		if info.ArchiveIndex == 0x7fff {
+			l.EmbeddedBytes += info.Length
+			continue
+		}
+
+		if _, ok := l.ArchiveBytes[info.ArchiveIndex]; !ok {
+			l.Archives = append(l.Archives, info.ArchiveIndex)
+		}
+		l.ArchiveBytes[info.ArchiveIndex] += info.Length
+	}
+
+	sort.Slice(l.Archives, func(i, j int) bool {
+		return l.Archives[i] < l.Archives[j]
+	})
+
+	return l
+}
@@ -0,0 +1,298 @@
+package vpk
+
+import (
+	"bufio"
+	"crypto/md5"
+	"encoding/binary"
+	"hash"
+	"io"
+	"os"
+)
+
+// archiveMD5ChunkSize is the maximum number of bytes of an archive covered by
+// a single entry in the archive MD5 section.
+const archiveMD5ChunkSize = 1 << 20
+
+// archiveMD5EntrySize is the encoded size of an archiveMD5Entry.
+const archiveMD5EntrySize = 28
+
+// otherMD5Size is the encoded size of an otherMD5.
+const otherMD5Size = 48
+
+// archiveMD5Entry is an entry in the archive MD5 section of a version 2 VPK.
+type archiveMD5Entry struct {
+	// The index of the archive this chunk is stored in.
+	ArchiveIndex uint32
+	// The offset of the chunk from the beginning of the archive.
+	Offset uint32
+	// The number of bytes in the chunk.
+	Length uint32
+	// The MD5 checksum of the chunk.
+	MD5 [md5.Size]byte
+}
+
+// otherMD5 is the other MD5 section of a version 2 VPK.
+type otherMD5 struct {
+	// The MD5 checksum of the directory tree.
+	TreeMD5 [md5.Size]byte
+	// The MD5 checksum of the archive MD5 section.
+	ArchiveMD5SectionMD5 [md5.Size]byte
+	// The MD5 checksum of the main VPK file, from the start of the header
+	// to the end of ArchiveMD5SectionMD5.
+	WholeFileMD5 [md5.Size]byte
+}
+
+// archiveMD5Writer is an io.Writer that computes archive MD5 section entries
+// for the data written to it.
+type archiveMD5Writer struct {
+	index   uint32
+	offset  uint32
+	length  uint32
+	hash    hash.Hash
+	entries []archiveMD5Entry
+}
+
+// next finishes the current archive and starts computing entries for the
+// archive with the given index.
+func (w *archiveMD5Writer) next(index int16) {
+	w.flush()
+	w.index = uint32(index)
+	w.offset = 0
+}
+
+// flush adds an entry for the partial chunk written so far, if any.
+func (w *archiveMD5Writer) flush() {
+	if w.hash == nil {
+		return
+	}
+
+	e := archiveMD5Entry{
+		ArchiveIndex: w.index,
+		Offset:       w.offset,
+		Length:       w.length,
+	}
+	w.hash.Sum(e.MD5[:0])
+	w.entries = append(w.entries, e)
+
+	w.offset += w.length
+	w.length = 0
+	w.hash = nil
+}
+
+func (w *archiveMD5Writer) Write(p []byte) (int, error) {
+	n := len(p)
+
+	for len(p) != 0 {
+		if w.hash == nil {
+			w.hash = md5.New()
+		}
+
+		chunk := p
+		if remaining := archiveMD5ChunkSize - w.length; uint32(len(chunk)) > remaining {
+			chunk = chunk[:remaining]
+		}
+		w.hash.Write(chunk)
+		w.length += uint32(len(chunk))
+		p = p[len(chunk):]
+
+		if w.length == archiveMD5ChunkSize {
+			w.flush()
+		}
+	}
+
+	return n, nil
+}
+
+// ArchiveChunk is the result of verifying one chunk of an archive against the
+// archive MD5 section of a version 2 VPK.
+type ArchiveChunk struct {
+	// The index of the archive this chunk is stored in.
+	ArchiveIndex int16
+	// The offset of the chunk from the beginning of the archive.
+	Offset uint32
+	// The number of bytes in the chunk.
+	Length uint32
+	// The MD5 checksum stored in the archive MD5 section.
+	Expected [md5.Size]byte
+	// The MD5 checksum of the data actually in the archive. It is only
+	// meaningful if Err is nil.
+	Actual [md5.Size]byte
+	// Err is non-nil if the chunk could not be read.
+	Err error
+}
+
+// OK returns true if the chunk was read successfully and its checksum matches.
+func (c *ArchiveChunk) OK() bool {
+	return c.Err == nil && c.Actual == c.Expected
+}
+
+// verifyOtherMD5 checks the checksums in the other MD5 section of a version 2
+// VPK against the contents of the main VPK file, f.
+func (v *VPK) verifyOtherMD5(f File) error {
+	if v.header2.OtherMD5SectionSize != otherMD5Size {
+		return ErrInvalidHeader
+	}
+
+	_, err := f.Seek(0, os.SEEK_SET)
+	if err != nil {
+		return err
+	}
+
+	r := bufio.NewReader(f)
+	whole, tree, section := md5.New(), md5.New(), md5.New()
+
+	_, err = io.CopyN(whole, r, v.headerLength())
+	if err == nil {
+		_, err = io.CopyN(io.MultiWriter(whole, tree), r, int64(v.treeLength))
+	}
+	if err == nil {
+		_, err = io.CopyN(whole, r, int64(v.header2.FileDataSectionSize))
+	}
+	if err == nil {
+		_, err = io.CopyN(io.MultiWriter(whole, section), r, int64(v.header2.ArchiveMD5SectionSize))
+	}
+	var other otherMD5
+	if err == nil {
+		err = binary.Read(r, binary.LittleEndian, &other)
+	}
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return err
+	}
+	whole.Write(other.TreeMD5[:])
+	whole.Write(other.ArchiveMD5SectionMD5[:])
+
+	check := func(which MD5Checksum, h hash.Hash, expected [md5.Size]byte) error {
+		var actual [md5.Size]byte
+		h.Sum(actual[:0])
+		if actual != expected {
+			return ErrMD5Mismatch{Checksum: which, Actual: actual, Expected: expected}
+		}
+		return nil
+	}
+
+	if err = check(TreeMD5, tree, other.TreeMD5); err != nil {
+		return err
+	}
+	if err = check(ArchiveMD5SectionMD5, section, other.ArchiveMD5SectionMD5); err != nil {
+		return err
+	}
+	return check(WholeFileMD5, whole, other.WholeFileMD5)
+}
+
+// readArchiveMD5 reads the archive MD5 section from the main VPK file.
+func (v *VPK) readArchiveMD5() ([]archiveMD5Entry, error) {
+	if v.version != 2 {
+		return nil, ErrNoArchiveMD5
+	}
+
+	f, err := v.opener.Main()
+	if err != nil {
+		if f != nil {
+			f.Close()
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	// Don't trust the header for the size of the allocation.
+	offset := v.dataOffset() + int64(v.header2.FileDataSectionSize)
+	if offset+int64(v.header2.ArchiveMD5SectionSize) > fi.Size() {
+		return nil, ErrInvalidHeader
+	}
+
+	_, err = f.Seek(offset, os.SEEK_SET)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]archiveMD5Entry, v.header2.ArchiveMD5SectionSize/archiveMD5EntrySize)
+	err = binary.Read(bufio.NewReader(f), binary.LittleEndian, entries)
+	if err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
+
+// VerifyArchiveMD5 checks each chunk listed in the archive MD5 section of a
+// version 2 VPK against the data in its archive. It returns ErrNoArchiveMD5 if
+// the VPK does not have an archive MD5 section. Problems reading individual
+// chunks are reported in the Err field of the corresponding ArchiveChunk.
+func (v *VPK) VerifyArchiveMD5() ([]ArchiveChunk, error) {
+	entries, err := v.readArchiveMD5()
+	if err != nil {
+		return nil, err
+	}
+
+	chunks := make([]ArchiveChunk, len(entries))
+
+	var f File
+	var base int64
+	var openErr error
+	index := int16(-1)
+	defer func() {
+		if f != nil {
+			f.Close()
+		}
+	}()
+
+	h := md5.New()
+	for i, e := range entries {
+		c := &chunks[i]
+		c.ArchiveIndex = int16(e.ArchiveIndex)
+		c.Offset = e.Offset
+		c.Length = e.Length
+		c.Expected = e.MD5
+
+		if e.ArchiveIndex > 0x7fff {
+			c.Err = ErrInvalidArchiveIndex(e.ArchiveIndex)
+			continue
+		}
+
+		if c.ArchiveIndex != index {
+			if f != nil {
+				f.Close()
+			}
+			index = c.ArchiveIndex
+			base = 0
+			if index == 0x7fff {
+				base = v.dataOffset()
+				f, openErr = v.opener.Main()
+			} else {
+				f, openErr = v.opener.Archive(index)
+			}
+			if openErr != nil && f != nil {
+				f.Close()
+				f = nil
+			}
+		}
+		if openErr != nil {
+			c.Err = openErr
+			continue
+		}
+
+		_, err = f.Seek(base+int64(e.Offset), os.SEEK_SET)
+		if err != nil {
+			c.Err = err
+			continue
+		}
+
+		h.Reset()
+		_, err = io.CopyN(h, f, int64(e.Length))
+		if err != nil {
+			c.Err = err
+			continue
+		}
+		h.Sum(c.Actual[:0])
+	}
+
+	return chunks, nil
+}
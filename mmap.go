@@ -0,0 +1,385 @@
+package vpk
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"sync"
+)
+
+// MappedOpener is an Opener for a VPK on the OS filesystem that maps each file
+// into memory the first time it is opened, instead of opening it again every
+// time a file in the VPK is read. The Files it returns implement io.ReaderAt,
+// so reads are copied straight out of the mapped memory, and EntryBytes can
+// return the contents of most files without copying them at all.
+//
+// The files stay mapped until Close is called, which VPK.Close does for VPKs
+// opened with a MappedOpener, and every File opened from them has been closed.
+// This includes the EntryReaders and io.ReadClosers returned by entries, so it
+// is safe to close the VPK while files are still being read.
+//
+// On platforms without memory mapping, each file is kept open and read from
+// directly instead, and Bytes and EntryBytes always return copies.
+type MappedOpener struct {
+	main    string
+	archive func(index int16) string
+
+	mu       sync.Mutex
+	closed   bool
+	mappings map[int16]*mapping
+}
+
+// mapping is a file mapped into memory, or an open file on platforms where
+// that is not possible.
+type mapping struct {
+	data []byte
+	file *os.File
+	info os.FileInfo
+
+	// refs is the number of open mappedFiles using the mapping, plus one
+	// for the MappedOpener itself until it is closed. It is protected by
+	// MappedOpener.mu.
+	refs int
+}
+
+// The mapping of the main VPK file is stored at this index.
+const mainMapping = 0x7fff
+
+var _ ArchiveLister = (*MappedOpener)(nil)
+
+// MappedSingleVPK returns a MappedOpener for a single-part VPK on the OS
+// filesystem.
+func MappedSingleVPK(path string) *MappedOpener {
+	return MappedMultiVPKNamed(path, nil)
+}
+
+// MappedMultiVPK returns a MappedOpener for a multi-part VPK on the OS
+// filesystem. prefix should be the part before "_dir.vpk". Like MultiVPK, it
+// supports the naming scheme used by Respawn Entertainment's games.
+func MappedMultiVPK(prefix string) *MappedOpener {
+	return MappedMultiVPKNamed(prefix+"_dir.vpk", defaultArchiveName(respawnArchivePrefix(prefix)))
+}
+
+// MappedMultiVPKNamed returns a MappedOpener for a multi-part VPK on the OS
+// filesystem with a custom naming scheme, as described by MultiVPKNamed. If
+// archive is nil, the VPK does not have any data-only archives.
+func MappedMultiVPKNamed(main string, archive func(index int16) string) *MappedOpener {
+	return &MappedOpener{
+		main:     main,
+		archive:  archive,
+		mappings: make(map[int16]*mapping),
+	}
+}
+
+func (o *MappedOpener) Main() (File, error) {
+	m, err := o.acquire(mainMapping)
+	if err != nil {
+		return nil, err
+	}
+	return o.open(m), nil
+}
+
+func (o *MappedOpener) Archive(index int16) (File, error) {
+	if o.archive == nil || index < 0 || index >= 0x7fff {
+		return nil, os.ErrNotExist
+	}
+
+	m, err := o.acquire(index)
+	if err != nil {
+		return nil, err
+	}
+	return o.open(m), nil
+}
+
+func (o *MappedOpener) Archives() ([]int16, error) {
+	if o.archive == nil {
+		return nil, nil
+	}
+	return listArchives(o.archive)
+}
+
+// Bytes returns the contents of the main VPK file if index is 0x7fff, or of
+// the data-only archive with the given index otherwise. The returned slice
+// must not be modified, and must not be used after Close is called. On
+// platforms without memory mapping, the whole file is read into a new slice.
+func (o *MappedOpener) Bytes(index int16) ([]byte, error) {
+	if index != mainMapping && (o.archive == nil || index < 0) {
+		return nil, os.ErrNotExist
+	}
+
+	m, err := o.acquire(index)
+	if err != nil {
+		return nil, err
+	}
+	defer o.release(m)
+
+	return m.slice(0, m.info.Size())
+}
+
+// EntryBytes returns the contents of a file in a VPK that was opened with o.
+// If the file is stored in one piece in the main VPK file or an archive, the
+// returned slice points directly into the mapped memory, so it must not be
+// modified, and must not be used after Close is called. Files that are split
+// between the directory tree and an archive, and compressed files, are copied
+// into a new slice instead.
+//
+// Unlike reading from Entry.Open, EntryBytes does not verify the CRC.
+func (o *MappedOpener) EntryBytes(ent Entry) ([]byte, error) {
+	var opener Opener
+	var info EntryInfo
+	var pre []byte
+	direct := false
+
+	switch e := ent.(type) {
+	case *vpkFileEntry:
+		opener, info, pre = e.o, e.i, e.p
+		direct = len(pre) == 0 || e.e.Length == 0
+	case *bloodlinesFileEntry:
+		opener, info = e.o, e.i
+		direct = true
+	case *respawnFileEntry:
+		opener, info, pre = e.o, e.i, e.p
+		direct = len(pre) == 0 && len(e.c) == 1 && e.c[0].CompressedLength == e.c[0].Length
+	}
+	if opener != Opener(o) {
+		return nil, &Error{Op: "read", Path: ent.Rel(), ArchiveIndex: info.ArchiveIndex, Offset: info.Offset, Err: os.ErrInvalid}
+	}
+
+	if !direct {
+		r, err := ent.(RandomAccessEntry).OpenReaderAt()
+		if err != nil {
+			return nil, err
+		}
+		b := make([]byte, r.Size())
+		_, err = r.ReadAt(b, 0)
+		if err == io.EOF {
+			err = nil
+		}
+		if e := r.Close(); err == nil {
+			err = e
+		}
+		if err != nil {
+			return nil, err
+		}
+		return b, nil
+	}
+
+	if info.Length == 0 {
+		return pre, nil
+	}
+
+	loc := Error{Path: ent.Rel(), ArchiveIndex: info.ArchiveIndex, Offset: info.Offset}
+
+	m, err := o.acquire(info.ArchiveIndex)
+	if err != nil {
+		return nil, loc.wrap("open", err)
+	}
+	defer o.release(m)
+
+	if info.Offset < 0 || info.Offset+info.Length > m.info.Size() {
+		return nil, loc.wrap("read", io.ErrUnexpectedEOF)
+	}
+
+	b, err := m.slice(info.Offset, info.Length)
+	if err != nil {
+		return nil, loc.wrap("read", err)
+	}
+	return b, nil
+}
+
+// Close unmaps all of the files mapped by o once the Files opened from them
+// are closed, and causes any further attempts to open files to return
+// os.ErrClosed. Slices returned by Bytes and EntryBytes must not be used after
+// Close is called.
+func (o *MappedOpener) Close() error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if o.closed {
+		return nil
+	}
+	o.closed = true
+
+	var err error
+	for index, m := range o.mappings {
+		if e := o.releaseLocked(m); err == nil {
+			err = e
+		}
+		delete(o.mappings, index)
+	}
+
+	return err
+}
+
+// acquire returns the mapping for the given archive index, or for the main
+// VPK file if index is 0x7fff, mapping the file if it has not been mapped yet.
+// The mapping stays valid until it is passed to release.
+func (o *MappedOpener) acquire(index int16) (*mapping, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if o.closed {
+		return nil, os.ErrClosed
+	}
+
+	if m, ok := o.mappings[index]; ok {
+		m.refs++
+		return m, nil
+	}
+
+	name := o.main
+	if index != mainMapping {
+		name = o.archive(index)
+	}
+
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	data, err := mapFile(f, info.Size())
+	if err != nil {
+		f.Close()
+		return nil, &os.PathError{Op: "mmap", Path: name, Err: err}
+	}
+
+	m := &mapping{data: data, info: info, refs: 2}
+	if data == nil && info.Size() != 0 {
+		// This platform can't map files, so read from the file itself.
+		m.file = f
+	} else if err = f.Close(); err != nil {
+		unmapFile(data)
+		return nil, err
+	}
+	o.mappings[index] = m
+
+	return m, nil
+}
+
+// release undoes a call to acquire.
+func (o *MappedOpener) release(m *mapping) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	return o.releaseLocked(m)
+}
+
+func (o *MappedOpener) releaseLocked(m *mapping) error {
+	m.refs--
+	if m.refs != 0 {
+		return nil
+	}
+
+	if m.file != nil {
+		return m.file.Close()
+	}
+	return unmapFile(m.data)
+}
+
+// readerAt returns an io.ReaderAt for the contents of the mapped file.
+func (m *mapping) readerAt() io.ReaderAt {
+	if m.file != nil {
+		return m.file
+	}
+	return bytes.NewReader(m.data)
+}
+
+// slice returns length bytes of the file starting at offset. If the file is
+// mapped, the returned slice points into the mapped memory.
+func (m *mapping) slice(offset, length int64) ([]byte, error) {
+	if m.file == nil {
+		return m.data[offset : offset+length : offset+length], nil
+	}
+
+	b := make([]byte, length)
+	if _, err := m.file.ReadAt(b, offset); err != nil && err != io.EOF {
+		return nil, err
+	}
+	return b, nil
+}
+
+// open returns a File that reads from m. It takes over the reference from
+// acquire, which is released when the File is closed.
+func (o *MappedOpener) open(m *mapping) File {
+	return &mappedFile{
+		r:    io.NewSectionReader(m.readerAt(), 0, m.info.Size()),
+		info: m.info,
+		o:    o,
+		m:    m,
+	}
+}
+
+// mappedFile is a File that reads from a mapping. The mapping is kept until
+// every mappedFile using it is closed. After a mappedFile is closed, its
+// methods return os.ErrClosed instead of reading from memory that may have
+// been unmapped.
+type mappedFile struct {
+	mu sync.RWMutex
+	r  *io.SectionReader
+
+	info os.FileInfo
+	o    *MappedOpener
+	m    *mapping
+}
+
+func (f *mappedFile) Read(p []byte) (int, error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	if f.r == nil {
+		return 0, os.ErrClosed
+	}
+	return f.r.Read(p)
+}
+
+func (f *mappedFile) ReadAt(p []byte, off int64) (int, error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	if f.r == nil {
+		return 0, os.ErrClosed
+	}
+	return f.r.ReadAt(p, off)
+}
+
+func (f *mappedFile) Seek(offset int64, whence int) (int64, error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	if f.r == nil {
+		return 0, os.ErrClosed
+	}
+	return f.r.Seek(offset, whence)
+}
+
+func (f *mappedFile) Stat() (os.FileInfo, error) {
+	return f.info, nil
+}
+
+func (f *mappedFile) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.r == nil {
+		return os.ErrClosed
+	}
+	f.r = nil
+
+	return f.o.release(f.m)
+}
+
+// Close releases any resources held by the Opener the VPK was opened with,
+// such as the memory mapped by a MappedOpener. Entries from the VPK must not
+// be read after Close is called. For other Openers, Close does nothing.
+func (v *VPK) Close() error {
+	if c, ok := v.opener.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
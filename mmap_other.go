@@ -0,0 +1,20 @@
+//go:build !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd
+
+package vpk
+
+import (
+	"os"
+)
+
+// mapFile would map f into memory, but this platform does not support memory
+// mapping. It returns nil, so MappedOpener reads from f directly instead of
+// reading the whole file into memory.
+func mapFile(f *os.File, size int64) ([]byte, error) {
+	return nil, nil
+}
+
+// unmapFile releases memory returned by mapFile, which is always nil on this
+// platform.
+func unmapFile(data []byte) error {
+	return nil
+}
@@ -0,0 +1,85 @@
+package vpk
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMappedOpener(t *testing.T) {
+	tv := multiTestVPK(t)
+	entries := testEntries()
+	tv.create(t, entries, nil)
+
+	o := MappedMultiVPK(filepath.Join(filepath.Dir(tv.main), "test"))
+	v, err := Open(o)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkEntries(t, v, entries)
+
+	for _, e := range entries {
+		b, err := o.EntryBytes(v.Entry(e.Rel()))
+		if err != nil {
+			t.Fatalf("%s: %v", e.Rel(), err)
+		}
+		if !bytes.Equal(b, e.(testEntry).data) {
+			t.Errorf("%s: contents do not match", e.Rel())
+		}
+	}
+
+	other, err := Open(tv.opener)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = o.EntryBytes(other.Entry("root.txt")); err == nil {
+		t.Error("EntryBytes accepted an entry from a different VPK")
+	}
+
+	if err = v.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = o.Main(); !errors.Is(err, os.ErrClosed) {
+		t.Errorf("expected os.ErrClosed, got %v", err)
+	}
+}
+
+func TestMappedOpenerCloseWhileReading(t *testing.T) {
+	tv := multiTestVPK(t)
+	tv.create(t, testEntries(), nil)
+
+	v, err := Open(MappedMultiVPK(filepath.Join(filepath.Dir(tv.main), "test")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := v.Entry("dir1/sub/file4.txt").(RandomAccessEntry).OpenReaderAt()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = v.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the archive must stay mapped until r is closed.
+	b := make([]byte, 100)
+	if _, err = r.ReadAt(b, 1000); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b, bytes.Repeat([]byte{4}, 100)) {
+		t.Error("contents do not match")
+	}
+	if err = r.VerifyCRC(); err != nil {
+		t.Error(err)
+	}
+
+	if err = r.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = r.ReadAt(b, 1000); !errors.Is(err, os.ErrClosed) {
+		t.Errorf("expected os.ErrClosed after Close, got %v", err)
+	}
+}
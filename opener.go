@@ -3,6 +3,8 @@ package vpk
 import (
 	"fmt"
 	"os"
+	"path/filepath"
+	"strings"
 )
 
 type Opener interface {
@@ -27,18 +29,211 @@ func (o singleVPKOpener) Archive(index int16) (File, error) {
 	return nil, os.ErrNotExist
 }
 
-type multiVPKOpener string
+type multiVPKOpener struct {
+	main    string
+	archive func(index int16) string
+}
 
 // MultiVPK implements an Opener for a multi-part VPK on the OS filesystem.
-// prefix should be the part before "_dir.vpk".
+// prefix should be the part before "_dir.vpk". VPKs from Respawn
+// Entertainment's games, where the main file has a language prefix that the
+// archives do not (englishclient_*_dir.vpk and client_*_000.vpk), are also
+// supported.
 func MultiVPK(prefix string) Opener {
-	return multiVPKOpener(prefix)
+	return MultiVPKNamed(prefix+"_dir.vpk", defaultArchiveName(respawnArchivePrefix(prefix)))
+}
+
+// MultiVPKNamed implements an Opener for a multi-part VPK on the OS
+// filesystem with a custom naming scheme. main is the path of the main VPK
+// file, and archive returns the path of the data-only archive with the given
+// index. See also ArchivePattern.
+//
+// The returned Opener also implements ArchiveLister.
+func MultiVPKNamed(main string, archive func(index int16) string) Opener {
+	return multiVPKOpener{main, archive}
 }
 
 func (o multiVPKOpener) Main() (File, error) {
-	return os.Open(string(o) + "_dir.vpk")
+	return os.Open(o.main)
 }
 
 func (o multiVPKOpener) Archive(index int16) (File, error) {
-	return os.Open(fmt.Sprintf("%s_%03d.vpk", string(o), index))
+	if index < 0 || index >= 0x7fff {
+		return nil, os.ErrNotExist
+	}
+	return os.Open(o.archive(index))
+}
+
+func (o multiVPKOpener) Archives() ([]int16, error) {
+	return listArchives(o.archive)
+}
+
+// defaultArchiveName returns the naming scheme used by Valve's tools, where
+// archives are named prefix_000.vpk, prefix_001.vpk, and so on. Indices above
+// 999 use as many digits as they need.
+func defaultArchiveName(prefix string) func(index int16) string {
+	return func(index int16) string {
+		return fmt.Sprintf("%s_%03d.vpk", prefix, index)
+	}
+}
+
+// respawnLanguages are the language prefixes Respawn Entertainment's games
+// add to the names of their main VPK files.
+var respawnLanguages = []string{
+	"english", "french", "german", "italian", "japanese", "korean",
+	"mspanish", "polish", "portuguese", "portugese", "russian",
+	"schinese", "spanish", "tchinese",
+}
+
+// respawnArchivePrefix returns the prefix of the archive names for the
+// multi-part VPK whose main file is prefix+"_dir.vpk". Respawn's main files
+// are named like englishclient_mp_common.bsp.pak000_dir.vpk, but the
+// archives leave out the language: client_mp_common.bsp.pak000_000.vpk. For
+// any other VPK, prefix is returned unchanged.
+func respawnArchivePrefix(prefix string) string {
+	dir, base := filepath.Split(prefix)
+	for _, lang := range respawnLanguages {
+		rest := strings.TrimPrefix(base, lang)
+		if len(rest) != len(base) && (strings.HasPrefix(rest, "client_") || strings.HasPrefix(rest, "server_")) {
+			return dir + rest
+		}
+	}
+	return prefix
+}
+
+// respawnMainPrefix is the inverse of respawnArchivePrefix. It returns the
+// prefix of an existing Respawn main file for the archives with the given
+// prefix, preferring English.
+func respawnMainPrefix(prefix string) (string, bool) {
+	dir, base := filepath.Split(prefix)
+	if !strings.HasPrefix(base, "client_") && !strings.HasPrefix(base, "server_") {
+		return "", false
+	}
+
+	for _, lang := range respawnLanguages {
+		if _, err := os.Stat(dir + lang + base + "_dir.vpk"); err == nil {
+			return dir + lang + base, true
+		}
+	}
+
+	return "", false
+}
+
+// ArchivePattern returns an archive naming function for MultiVPKNamed or
+// MultiVPKCreatorNamed. pattern is a format string for fmt.Sprintf with a
+// single integer verb, such as "pak01_%03d.vpk".
+func ArchivePattern(pattern string) func(index int16) string {
+	return func(index int16) string {
+		return fmt.Sprintf(pattern, index)
+	}
+}
+
+// ArchiveLister is implemented by Openers that can find which data-only
+// archives exist.
+type ArchiveLister interface {
+	// Archives returns the indices of the archives that exist, in
+	// increasing order.
+	Archives() ([]int16, error)
+}
+
+// listArchives returns the indices of the archives named by archive that
+// exist on the OS filesystem. Each directory is only read once, so this does
+// not need to check every possible index individually.
+func listArchives(archive func(index int16) string) ([]int16, error) {
+	dirs := make(map[string]map[string]bool)
+
+	var indices []int16
+	for i := int16(0); i < 0x7fff; i++ {
+		name := archive(i)
+		dir, base := filepath.Split(name)
+
+		names, ok := dirs[dir]
+		if !ok {
+			list := dir
+			if list == "" {
+				list = "."
+			}
+			f, err := os.Open(list)
+			if err != nil {
+				return nil, err
+			}
+			all, err := f.Readdirnames(-1)
+			f.Close()
+			if err != nil {
+				return nil, err
+			}
+
+			names = make(map[string]bool, len(all))
+			for _, n := range all {
+				names[n] = true
+			}
+			dirs[dir] = names
+		}
+
+		if names[base] {
+			indices = append(indices, i)
+		}
+	}
+
+	return indices, nil
+}
+
+// OpenerForPath returns an Opener for the VPK that path belongs to. path may
+// be a single-part VPK, the main file of a multi-part VPK (*_dir.vpk), or one
+// of the data-only archives of a multi-part VPK (*_###.vpk). An archive is
+// only treated as part of a multi-part VPK if the matching *_dir.vpk exists.
+// Valve's naming scheme and Respawn Entertainment's (see MultiVPK) are
+// recognized; VPKs named any other way need MultiVPKNamed.
+func OpenerForPath(path string) Opener {
+	if strings.HasSuffix(path, "_dir.vpk") {
+		return MultiVPK(path[:len(path)-len("_dir.vpk")])
+	}
+
+	if prefix, ok := archivePrefix(path); ok {
+		if _, err := os.Stat(prefix + "_dir.vpk"); err == nil {
+			return MultiVPK(prefix)
+		}
+		if main, ok := respawnMainPrefix(prefix); ok {
+			return MultiVPK(main)
+		}
+	}
+
+	return SingleVPK(path)
+}
+
+// archivePrefix returns the part of path before "_###.vpk", where ### is one
+// or more digits.
+func archivePrefix(path string) (string, bool) {
+	if !strings.HasSuffix(path, ".vpk") {
+		return "", false
+	}
+	name := path[:len(path)-len(".vpk")]
+
+	i := strings.LastIndexByte(name, '_')
+	if i == -1 || i == len(name)-1 {
+		return "", false
+	}
+	for _, c := range name[i+1:] {
+		if c < '0' || c > '9' {
+			return "", false
+		}
+	}
+
+	return name[:i], true
+}
+
+// OpenPath opens the VPK that path belongs to on the OS filesystem, as
+// described by OpenerForPath. The format and version are detected from the
+// contents of the main VPK file. VPKs without a header are allowed
+// regardless of opts.AllowHeaderless, but as described there, a file that
+// does not contain an intact directory tree is reported as ErrInvalidMagic.
+// This includes a data-only archive whose main VPK file cannot be found.
+func OpenPath(path string, opts *OpenOptions) (*VPK, error) {
+	var o OpenOptions
+	if opts != nil {
+		o = *opts
+	}
+	o.AllowHeaderless = true
+
+	return OpenWithOptions(OpenerForPath(path), &o)
 }
@@ -0,0 +1,226 @@
+package vpk
+
+import (
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+)
+
+// Walk walks the file tree of the VPK rooted at root, calling fn for each file
+// or directory in the tree, including root. It behaves exactly like
+// fs.WalkDir on the file system returned by FS, so root must be a valid path
+// as defined by fs.ValidPath, and "." is the root of the VPK.
+func (v *VPK) Walk(root string, fn fs.WalkDirFunc) error {
+	return fs.WalkDir(v.FS(), root, fn)
+}
+
+// Glob returns the paths of the files in the VPK that match pattern, in the
+// same order as Paths. The pattern syntax is the same as in path.Match, except
+// that an element of the pattern that is exactly "**" matches zero or more
+// directories. For example, "materials/**/*.vmt" matches every .vmt file in
+// the materials directory and its subdirectories.
+//
+// Paths are matched case-insensitively. The only possible returned error is
+// path.ErrBadPattern, when pattern is malformed.
+func (v *VPK) Glob(pattern string) ([]string, error) {
+	elems := strings.Split(lowerASCII(pattern), "/")
+	for _, e := range elems {
+		if e == "**" {
+			continue
+		}
+		if _, err := path.Match(e, ""); err != nil {
+			return nil, err
+		}
+	}
+
+	// Use the literal directories at the start of the pattern and the
+	// literal extension at the end of the pattern, if there are any, to
+	// avoid looking at files that can't possibly match.
+	var dir []string
+	for _, e := range elems[:len(elems)-1] {
+		if e == "**" || hasMeta(e) {
+			break
+		}
+		dir = append(dir, e)
+	}
+
+	var indices []int
+	last := elems[len(elems)-1]
+	if i := strings.LastIndexByte(last, '.'); i != -1 && last != "**" && !hasMeta(last[i+1:]) {
+		indices = v.entries.under(strings.Join(dir, "/"), last[i+1:], false)
+	} else {
+		indices = v.entries.under(strings.Join(dir, "/"), "", true)
+	}
+
+	var matches []string
+	for _, i := range indices {
+		e := &v.entries[i]
+		rel := joinPath(e.dir, e.base, e.ext)
+		if ok, err := matchElems(elems, strings.Split(rel, "/")); err != nil {
+			return nil, err
+		} else if ok {
+			matches = append(matches, rel)
+		}
+	}
+
+	return matches, nil
+}
+
+// hasMeta reports whether s contains any of the characters that have a special
+// meaning in path.Match.
+func hasMeta(s string) bool {
+	return strings.ContainsAny(s, `*?[]\`)
+}
+
+// matchElems reports whether the path elements in name match the pattern
+// elements in pattern, as described in VPK.Glob.
+func matchElems(pattern, name []string) (bool, error) {
+	for len(pattern) != 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(name); i++ {
+				if ok, err := matchElems(pattern[1:], name[i:]); ok || err != nil {
+					return ok, err
+				}
+			}
+			return false, nil
+		}
+
+		if len(name) == 0 {
+			return false, nil
+		}
+		if ok, err := path.Match(pattern[0], name[0]); !ok || err != nil {
+			return false, err
+		}
+
+		pattern, name = pattern[1:], name[1:]
+	}
+
+	return len(name) == 0, nil
+}
+
+// PathsWithExt returns the paths of the files in the VPK with the extension
+// ext, in the same order as Paths. The extension may be given with or without
+// a leading dot. If ext is empty, PathsWithExt returns the files that do not
+// have an extension.
+func (v *VPK) PathsWithExt(ext string) []string {
+	return v.paths(v.entries.under("", ext, false))
+}
+
+// PathsUnder returns the paths of the files in the directory dir and all of
+// its subdirectories, in the same order as Paths. The directory is normalized
+// the same way as the path in VPK.Entry, so "" is the root of the VPK.
+func (v *VPK) PathsUnder(dir string) []string {
+	dir, ok := cleanPath(dir)
+	if !ok {
+		return nil
+	}
+
+	return v.paths(v.entries.under(dir, "", true))
+}
+
+// PathsUnderWithExt returns the paths of the files that would be returned by
+// both PathsUnder(dir) and PathsWithExt(ext). For example, passing
+// "materials/models" and "vmt" returns all of the model materials.
+func (v *VPK) PathsUnderWithExt(dir, ext string) []string {
+	dir, ok := cleanPath(dir)
+	if !ok {
+		return nil
+	}
+
+	return v.paths(v.entries.under(dir, ext, false))
+}
+
+// paths returns the paths of the entries with the given indices.
+func (v *VPK) paths(indices []int) []string {
+	if len(indices) == 0 {
+		return nil
+	}
+
+	paths := make([]string, len(indices))
+
+	for i, j := range indices {
+		e := &v.entries[j]
+		paths[i] = joinPath(e.dir, e.base, e.ext)
+	}
+
+	return paths
+}
+
+// under returns the indices of the entries in s that are in the directory dir
+// or one of its subdirectories and have the extension ext, or any extension if
+// anyExt is true. dir must already be normalized by cleanPath. Because s is
+// sorted by extension and then by directory, each extension only takes a few
+// binary searches.
+func (s entrysort) under(dir, ext string, anyExt bool) []int {
+	var indices []int
+
+	if anyExt {
+		for lo := 0; lo < len(s); {
+			_, hi := s.extRange(s[lo].ext)
+			indices = s.appendUnder(indices, lo, hi, dir)
+			lo = hi
+		}
+	} else {
+		ext = lowerASCII(strings.TrimPrefix(ext, "."))
+		if ext == "" {
+			ext = " "
+		}
+		lo, hi := s.extRange(ext)
+		indices = s.appendUnder(indices, lo, hi, dir)
+	}
+
+	return indices
+}
+
+// extRange returns the indices of the first entry in s with the extension ext
+// and one past the last.
+func (s entrysort) extRange(ext string) (lo, hi int) {
+	lo = sort.Search(len(s), func(i int) bool {
+		return s[i].ext >= ext
+	})
+	hi = lo + sort.Search(len(s)-lo, func(i int) bool {
+		return s[lo+i].ext > ext
+	})
+	return
+}
+
+// dirRange returns the indices of the first entry in s[lo:hi] with a directory
+// that is at least min and one past the last entry with a directory that is
+// less than max. All of the entries in s[lo:hi] must have the same extension.
+func (s entrysort) dirRange(lo, hi int, min, max string) (int, int) {
+	start := lo + sort.Search(hi-lo, func(i int) bool {
+		return s[lo+i].dir >= min
+	})
+	end := start + sort.Search(hi-start, func(i int) bool {
+		return s[start+i].dir >= max
+	})
+	return start, end
+}
+
+// appendUnder appends the indices of the entries in s[lo:hi] that are in the
+// directory dir or one of its subdirectories to indices. All of the entries in
+// s[lo:hi] must have the same extension.
+func (s entrysort) appendUnder(indices []int, lo, hi int, dir string) []int {
+	if dir == "" {
+		for i := lo; i < hi; i++ {
+			indices = append(indices, i)
+		}
+		return indices
+	}
+
+	// Directories that start with dir+"/" are all next to each other, but
+	// something like dir+"-old" could be between them and dir itself, so
+	// look for them separately.
+	start, end := s.dirRange(lo, hi, dir, dir+"\x00")
+	for i := start; i < end; i++ {
+		indices = append(indices, i)
+	}
+
+	start, end = s.dirRange(lo, hi, dir+"/", dir+"0")
+	for i := start; i < end; i++ {
+		indices = append(indices, i)
+	}
+
+	return indices
+}
@@ -0,0 +1,335 @@
+package vpk
+
+import (
+	"hash/crc32"
+	"io"
+	"os"
+	"sort"
+	"sync"
+)
+
+// RandomAccessEntry is an Entry that can be read in any order. The Entry
+// values returned by VPK.Entry implement RandomAccessEntry.
+type RandomAccessEntry interface {
+	Entry
+
+	// OpenReaderAt opens the file for random access.
+	OpenReaderAt() (*EntryReader, error)
+}
+
+var (
+	_ RandomAccessEntry = (*vpkFileEntry)(nil)
+	_ RandomAccessEntry = (*respawnFileEntry)(nil)
+	_ RandomAccessEntry = (*bloodlinesFileEntry)(nil)
+)
+
+// EntryReader provides random access to the contents of a file in a VPK,
+// including any data preloaded from the directory tree. ReadAt may be called
+// concurrently, but Read and Seek share a position and may not.
+//
+// Unlike the io.ReadCloser returned by Entry.Open, closing an EntryReader
+// does not verify the CRC of the file. Call VerifyCRC to do that.
+type EntryReader struct {
+	*io.SectionReader
+
+	r     io.ReaderAt
+	close func() error
+	crc   uint32
+	check bool
+	loc   Error
+}
+
+func newEntryReader(r io.ReaderAt, size int64, close func() error, crc uint32, check bool, loc Error) *EntryReader {
+	r = &errorReaderAt{r, loc}
+	return &EntryReader{
+		SectionReader: io.NewSectionReader(r, 0, size),
+
+		r:     r,
+		close: close,
+		crc:   crc,
+		check: check,
+		loc:   loc,
+	}
+}
+
+// Close releases the archive opened for this EntryReader.
+func (r *EntryReader) Close() error {
+	if r.close == nil {
+		return nil
+	}
+	if err := r.close(); err != nil {
+		return r.loc.wrap("close", err)
+	}
+	return nil
+}
+
+// VerifyCRC reads the whole file and compares its CRC to the one stored in
+// the directory tree. It does not change the position used by Read and Seek,
+// and may be called concurrently with ReadAt. For VPKs without checksums
+// (Vampire: The Masquerade - Bloodlines), it always returns nil.
+func (r *EntryReader) VerifyCRC() error {
+	if !r.check {
+		return nil
+	}
+
+	hash := crc32.NewIEEE()
+	_, err := io.Copy(hash, io.NewSectionReader(r.r, 0, r.Size()))
+	if err != nil {
+		return err
+	}
+
+	return r.checkCRC(hash.Sum32())
+}
+
+// checkCRC compares actual to the CRC stored in the directory tree.
+func (r *EntryReader) checkCRC(actual uint32) error {
+	if r.check && actual != r.crc {
+		return r.loc.wrap("verify", ErrCRCMismatch{Actual: actual, Expected: r.crc})
+	}
+
+	return nil
+}
+
+// readerAt returns an io.ReaderAt for f. If f does not implement io.ReaderAt
+// itself, its Seek and Read methods are used with a lock held.
+func readerAt(f File) io.ReaderAt {
+	if r, ok := f.(io.ReaderAt); ok {
+		return r
+	}
+	return &lockedReaderAt{f: f}
+}
+
+type lockedReaderAt struct {
+	mu sync.Mutex
+	f  File
+}
+
+func (r *lockedReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	_, err := r.f.Seek(off, os.SEEK_SET)
+	if err != nil {
+		return 0, err
+	}
+
+	return io.ReadFull(r.f, p)
+}
+
+type errorReaderAt struct {
+	r   io.ReaderAt
+	loc Error
+}
+
+func (r *errorReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	n, err := r.r.ReadAt(p, off)
+	if err != nil && err != io.EOF {
+		err = r.loc.wrap("read", err)
+	}
+	return n, err
+}
+
+// preloadReaderAt presents the preloaded data of a file followed by length
+// bytes starting at offset in r as a single io.ReaderAt.
+type preloadReaderAt struct {
+	pre    []byte
+	r      io.ReaderAt
+	offset int64
+	length int64
+}
+
+func (r *preloadReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, os.ErrInvalid
+	}
+
+	var n int
+	if off < int64(len(r.pre)) {
+		n = copy(p, r.pre[off:])
+		p = p[n:]
+		off += int64(n)
+	}
+	if len(p) == 0 {
+		return n, nil
+	}
+
+	off -= int64(len(r.pre))
+	if off >= r.length {
+		return n, io.EOF
+	}
+
+	short := false
+	if remaining := r.length - off; int64(len(p)) > remaining {
+		p = p[:remaining]
+		short = true
+	}
+
+	m, err := r.r.ReadAt(p, r.offset+off)
+	n += m
+	if err == nil && short {
+		err = io.EOF
+	}
+	return n, err
+}
+
+func (e *vpkFileEntry) OpenReaderAt() (*EntryReader, error) {
+	loc := Error{Path: e.r, ArchiveIndex: e.i.ArchiveIndex, Offset: e.i.Offset}
+
+	if e.e.Length == 0 {
+		return newEntryReader(&preloadReaderAt{pre: e.p}, e.i.Size, nil, e.e.CRC, true, loc), nil
+	}
+
+	var f File
+	var err error
+	if e.e.ArchiveIndex == 0x7fff {
+		f, err = e.o.Main()
+	} else {
+		f, err = e.o.Archive(e.e.ArchiveIndex)
+	}
+	if err != nil {
+		if f != nil {
+			f.Close()
+		}
+		return nil, loc.wrap("open", err)
+	}
+
+	r := &preloadReaderAt{
+		pre:    e.p,
+		r:      readerAt(f),
+		offset: e.i.Offset,
+		length: int64(e.e.Length),
+	}
+
+	return newEntryReader(r, e.i.Size, f.Close, e.e.CRC, true, loc), nil
+}
+
+func (e *bloodlinesFileEntry) OpenReaderAt() (*EntryReader, error) {
+	loc := Error{Path: e.r, ArchiveIndex: 0x7fff, Offset: e.i.Offset}
+
+	f, err := e.o.Main()
+	if err != nil {
+		if f != nil {
+			f.Close()
+		}
+		return nil, loc.wrap("open", err)
+	}
+
+	r := &preloadReaderAt{
+		r:      readerAt(f),
+		offset: e.i.Offset,
+		length: e.i.Length,
+	}
+
+	return newEntryReader(r, e.i.Size, f.Close, 0, false, loc), nil
+}
+
+func (e *respawnFileEntry) OpenReaderAt() (*EntryReader, error) {
+	loc := Error{Path: e.r, ArchiveIndex: e.i.ArchiveIndex, Offset: e.i.Offset}
+
+	var f File
+	var err error
+	var base int64
+	if e.e.ArchiveIndex == 0x7fff {
+		f, err = e.o.Main()
+		base = e.b
+	} else {
+		f, err = e.o.Archive(e.e.ArchiveIndex)
+	}
+	if err != nil {
+		if f != nil {
+			f.Close()
+		}
+		return nil, loc.wrap("open", err)
+	}
+
+	chunks := &respawnReaderAt{
+		r:      readerAt(f),
+		b:      base,
+		c:      e.c,
+		d:      e.d,
+		starts: make([]int64, len(e.c)),
+		cached: -1,
+	}
+	var length int64
+	for i, c := range e.c {
+		chunks.starts[i] = length
+		length += int64(c.Length)
+	}
+
+	r := &preloadReaderAt{
+		pre:    e.p,
+		r:      chunks,
+		length: length,
+	}
+
+	return newEntryReader(r, e.i.Size, f.Close, e.e.CRC, true, loc), nil
+}
+
+// respawnReaderAt provides random access to the decompressed chunks of a file
+// in a Respawn VPK. The most recently decompressed chunk is kept in memory.
+type respawnReaderAt struct {
+	r      io.ReaderAt
+	b      int64
+	c      []respawnChunk
+	d      func(dst, src []byte) error
+	starts []int64
+
+	mu     sync.Mutex
+	cached int
+	buf    []byte
+}
+
+func (r *respawnReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	var n int
+
+	i := sort.Search(len(r.starts), func(i int) bool {
+		return r.starts[i] > off
+	}) - 1
+
+	for ; len(p) != 0 && i >= 0 && i < len(r.c); i++ {
+		c := r.c[i]
+		start := off - r.starts[i]
+		if start >= int64(c.Length) {
+			continue
+		}
+
+		var m int
+		if c.CompressedLength == c.Length {
+			end := int64(len(p))
+			if end > int64(c.Length)-start {
+				end = int64(c.Length) - start
+			}
+			var err error
+			m, err = r.r.ReadAt(p[:end], r.b+int64(c.Offset)+start)
+			if err == io.EOF && int64(m) == end {
+				err = nil
+			} else if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			if err != nil {
+				return n + m, err
+			}
+		} else {
+			r.mu.Lock()
+			if r.cached != i {
+				buf, err := readRespawnChunk(r.r, r.b, c, r.d)
+				if err != nil {
+					r.mu.Unlock()
+					return n, err
+				}
+				r.cached, r.buf = i, buf
+			}
+			m = copy(p, r.buf[start:])
+			r.mu.Unlock()
+		}
+
+		n += m
+		p = p[m:]
+		off += int64(m)
+	}
+
+	if len(p) != 0 {
+		return n, io.EOF
+	}
+	return n, nil
+}
@@ -0,0 +1,190 @@
+package vpk
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+)
+
+// respawnVersion is the version number used by VPKs from Respawn
+// Entertainment's games (Titanfall, Titanfall 2, Apex Legends). It is major
+// version 2, minor version 3.
+const respawnVersion = 0x00030002
+
+// respawnentry is the start of a directory tree entry in a Respawn VPK. It is
+// followed by one or more respawnChunk structures, each followed by a uint16
+// that is 0 if there are more chunks or 0xffff after the last chunk.
+type respawnentry struct {
+	// An IEEE 32-bit CRC checksum of the entire uncompressed file.
+	CRC uint32
+	// The number of bytes of data from the file that are included directly
+	// after the last chunk in the directory tree.
+	PreloadBytes uint16
+	// The index of the archive this file is stored in.
+	ArchiveIndex uint16
+}
+
+type respawnChunk struct {
+	// Flags used by the engine when loading this chunk.
+	LoadFlags uint32
+	// Flags used by the engine for texture chunks.
+	TextureFlags uint16
+	// Offset is relative to the beginning of the archive.
+	Offset uint64
+	// CompressedLength is the amount of data starting from Offset.
+	CompressedLength uint64
+	// Length is the size of the chunk after decompression. If it is the
+	// same as CompressedLength, the chunk is not compressed.
+	Length uint64
+}
+
+// readRespawnEntry reads a directory tree entry from a Respawn VPK, not
+// including the preloaded data.
+func readRespawnEntry(r io.Reader) (vpkentry, []respawnChunk, bool, error) {
+	var re respawnentry
+	err := binary.Read(r, binary.LittleEndian, &re)
+	if err != nil {
+		return vpkentry{}, nil, false, err
+	}
+
+	e := vpkentry{
+		CRC:          re.CRC,
+		PreloadBytes: re.PreloadBytes,
+		ArchiveIndex: int16(re.ArchiveIndex),
+		Terminator:   0xffff,
+	}
+
+	var chunks []respawnChunk
+	for {
+		var c respawnChunk
+		err = binary.Read(r, binary.LittleEndian, &c)
+		if err != nil {
+			return e, nil, false, err
+		}
+		chunks = append(chunks, c)
+
+		var terminator uint16
+		err = binary.Read(r, binary.LittleEndian, &terminator)
+		if err != nil {
+			return e, nil, false, err
+		}
+		if terminator == 0xffff {
+			break
+		}
+		if terminator != 0 {
+			return e, nil, false, nil
+		}
+	}
+
+	return e, chunks, e.ArchiveIndex >= 0, nil
+}
+
+type respawnFileEntry struct {
+	entryStat
+	o Opener
+	b int64
+	e vpkentry
+	p []byte
+	c []respawnChunk
+	d func(dst, src []byte) error
+}
+
+func (e *respawnFileEntry) Open() (io.ReadCloser, error) {
+	offset := int64(-1)
+	if len(e.c) != 0 {
+		offset = int64(e.c[0].Offset)
+		if e.e.ArchiveIndex == 0x7fff {
+			offset += e.b
+		}
+	}
+
+	return wrapErrors(e.open, e.r, e.e.ArchiveIndex, offset)
+}
+
+func (e *respawnFileEntry) open() (io.ReadCloser, error) {
+	var f File
+	var err error
+	var base int64
+	if e.e.ArchiveIndex == 0x7fff {
+		f, err = e.o.Main()
+		base = e.b
+	} else {
+		f, err = e.o.Archive(e.e.ArchiveIndex)
+	}
+	if err != nil {
+		if f != nil {
+			f.Close()
+		}
+		return nil, err
+	}
+
+	r := &respawnChunkReader{f: readerAt(f), b: base, c: e.c, d: e.d}
+
+	return crcReader(io.MultiReader(bytes.NewReader(e.p), r), f.Close, e.e.CRC), nil
+}
+
+// respawnChunkReader reads and decompresses the chunks of a file in a Respawn
+// VPK, one chunk at a time.
+type respawnChunkReader struct {
+	f   io.ReaderAt
+	b   int64
+	c   []respawnChunk
+	d   func(dst, src []byte) error
+	buf []byte
+	err error
+}
+
+func (r *respawnChunkReader) Read(p []byte) (int, error) {
+	for len(r.buf) == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+		if len(r.c) == 0 {
+			return 0, io.EOF
+		}
+
+		r.buf, r.err = readRespawnChunk(r.f, r.b, r.c[0], r.d)
+		r.c = r.c[1:]
+	}
+
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+// readRespawnChunk reads a chunk of a file in a Respawn VPK from r and
+// decompresses it with d if needed. Chunk offsets are relative to base.
+func readRespawnChunk(r io.ReaderAt, base int64, c respawnChunk, d func(dst, src []byte) error) ([]byte, error) {
+	if c.CompressedLength > respawnMaxChunkLength || c.Length > respawnMaxChunkLength {
+		return nil, ErrFileTooBig
+	}
+
+	compressed := make([]byte, c.CompressedLength)
+	_, err := r.ReadAt(compressed, base+int64(c.Offset))
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if c.CompressedLength == c.Length {
+		return compressed, nil
+	}
+
+	if d == nil {
+		return nil, ErrUnsupportedCompression
+	}
+
+	chunk := make([]byte, c.Length)
+	if err = d(chunk, compressed); err != nil {
+		return nil, err
+	}
+
+	return chunk, nil
+}
+
+// respawnMaxChunkLength is the largest chunk that will be read from a Respawn
+// VPK. The engine splits files into chunks of at most 1 MiB, so anything
+// larger than this is corrupt.
+const respawnMaxChunkLength = 16 << 20
@@ -0,0 +1,91 @@
+package vpk
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+// writeRespawnVPK writes a Respawn VPK containing a single file, dir/file.txt,
+// named the way Respawn's games name them. The first chunk of the file is
+// stored uncompressed, and the second chunk is "compressed" by reversing it.
+func writeRespawnVPK(t *testing.T, data []byte) (dir string) {
+	dir = t.TempDir()
+
+	split := len(data) / 2
+	rest := data[split:]
+	reversed := make([]byte, len(rest)+1)
+	for i, b := range rest {
+		reversed[len(rest)-1-i] = b
+	}
+
+	archive := append(append([]byte(nil), data[:split]...), reversed...)
+	if err := ioutil.WriteFile(filepath.Join(dir, "client_mp_test.bsp.pak000_000.vpk"), archive, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var tree bytes.Buffer
+	tree.WriteString("txt\x00dir\x00file\x00")
+	binary.Write(&tree, binary.LittleEndian, respawnentry{CRC: crc32.ChecksumIEEE(data)})
+	binary.Write(&tree, binary.LittleEndian, respawnChunk{Offset: 0, CompressedLength: uint64(split), Length: uint64(split)})
+	binary.Write(&tree, binary.LittleEndian, uint16(0))
+	binary.Write(&tree, binary.LittleEndian, respawnChunk{Offset: uint64(split), CompressedLength: uint64(len(reversed)), Length: uint64(len(rest))})
+	binary.Write(&tree, binary.LittleEndian, uint16(0xffff))
+	tree.WriteString("\x00\x00\x00")
+
+	var main bytes.Buffer
+	binary.Write(&main, binary.LittleEndian, []uint32{0x55aa1234, respawnVersion, uint32(tree.Len()), 0})
+	tree.WriteTo(&main)
+	if err := ioutil.WriteFile(filepath.Join(dir, "englishclient_mp_test.bsp.pak000_dir.vpk"), main.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	return dir
+}
+
+func reverseLZHAM(dst, src []byte) error {
+	for i := range dst {
+		dst[i] = src[len(dst)-1-i]
+	}
+	return nil
+}
+
+func TestRespawn(t *testing.T) {
+	data := []byte("hello world, this is respawn data!")
+	dir := writeRespawnVPK(t, data)
+
+	for _, name := range []string{
+		"englishclient_mp_test.bsp.pak000_dir.vpk",
+		"client_mp_test.bsp.pak000_000.vpk",
+	} {
+		v, err := OpenPath(filepath.Join(dir, name), nil)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if v.Format() != FormatRespawn {
+			t.Errorf("%s: format is %v", name, v.Format())
+		}
+
+		_, err = readEntry(v.Entry("dir/file.txt"))
+		if !errors.Is(err, ErrUnsupportedCompression) {
+			t.Errorf("%s: expected ErrUnsupportedCompression, got %v", name, err)
+		}
+
+		v, err = OpenPath(filepath.Join(dir, name), &OpenOptions{DecompressLZHAM: reverseLZHAM})
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+
+		b, err := readEntry(v.Entry("dir/file.txt"))
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if !bytes.Equal(b, data) {
+			t.Errorf("%s: expected %q, got %q", name, data, b)
+		}
+	}
+}
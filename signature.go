@@ -0,0 +1,157 @@
+package vpk
+
+import (
+	"bufio"
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/binary"
+	"io"
+	"os"
+)
+
+// signatureOffset returns the offset of the signature section from the start
+// of the main VPK file. It is only meaningful for version 2 VPKs.
+func (v *VPK) signatureOffset() int64 {
+	return v.dataOffset() +
+		int64(v.header2.FileDataSectionSize) +
+		int64(v.header2.ArchiveMD5SectionSize) +
+		int64(v.header2.OtherMD5SectionSize)
+}
+
+// readSignature reads the DER-encoded public key and the signature from the
+// signature section of a version 2 VPK.
+func (v *VPK) readSignature() (key, sig []byte, err error) {
+	if v.version != 2 || v.header2.SignatureSectionSize == 0 {
+		return nil, nil, ErrNotSigned
+	}
+
+	f, err := v.opener.Main()
+	if err != nil {
+		if f != nil {
+			f.Close()
+		}
+		return nil, nil, err
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Don't trust the header for the size of the allocations.
+	if v.signatureOffset()+int64(v.header2.SignatureSectionSize) > fi.Size() {
+		return nil, nil, ErrInvalidSignatureSection
+	}
+
+	_, err = f.Seek(v.signatureOffset(), os.SEEK_SET)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	r := io.LimitReader(bufio.NewReader(f), int64(v.header2.SignatureSectionSize))
+
+	readBytes := func() ([]byte, error) {
+		var length uint32
+		if err := binary.Read(r, binary.LittleEndian, &length); err != nil {
+			return nil, err
+		}
+		if int64(length) > int64(v.header2.SignatureSectionSize) {
+			return nil, ErrInvalidSignatureSection
+		}
+		b := make([]byte, length)
+		if _, err := io.ReadFull(r, b); err != nil {
+			return nil, err
+		}
+		return b, nil
+	}
+
+	key, err = readBytes()
+	if err == nil {
+		sig, err = readBytes()
+	}
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		err = ErrInvalidSignatureSection
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return key, sig, nil
+}
+
+// PublicKey returns the public key embedded in the signature section of a
+// signed version 2 VPK. It returns ErrNotSigned if the VPK is not signed.
+func (v *VPK) PublicKey() (*rsa.PublicKey, error) {
+	key, _, err := v.readSignature()
+	if err != nil {
+		return nil, err
+	}
+
+	return parsePublicKey(key)
+}
+
+func parsePublicKey(der []byte) (*rsa.PublicKey, error) {
+	pub, err := x509.ParsePKIXPublicKey(der)
+	if err != nil {
+		return nil, err
+	}
+
+	rsaPub, ok := pub.(*rsa.PublicKey)
+	if !ok {
+		return nil, ErrInvalidSignatureSection
+	}
+
+	return rsaPub, nil
+}
+
+// VerifySignature checks the RSA signature of a signed version 2 VPK against
+// the contents of the main VPK file. If trusted is non-nil, the public key
+// embedded in the VPK must be the same as trusted. Otherwise, the signature
+// only shows that the main VPK file was not modified after it was signed by
+// whoever holds the embedded key.
+//
+// The signature does not cover archives directly, but it does cover the
+// archive MD5 section, so VerifyArchiveMD5 should also be called to check
+// multi-part VPKs.
+func (v *VPK) VerifySignature(trusted *rsa.PublicKey) error {
+	key, sig, err := v.readSignature()
+	if err != nil {
+		return err
+	}
+
+	pub, err := parsePublicKey(key)
+	if err != nil {
+		return err
+	}
+
+	if trusted != nil && !trusted.Equal(pub) {
+		return ErrUntrustedKey
+	}
+
+	f, err := v.opener.Main()
+	if err != nil {
+		if f != nil {
+			f.Close()
+		}
+		return err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	_, err = io.CopyN(h, f, v.signatureOffset())
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return err
+	}
+
+	if rsa.VerifyPKCS1v15(pub, crypto.SHA256, h.Sum(nil), sig) != nil {
+		return ErrInvalidSignature
+	}
+
+	return nil
+}
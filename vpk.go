@@ -4,13 +4,22 @@ package vpk
 import (
 	"bufio"
 	"bytes"
+	"crypto"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
 	"encoding/binary"
+	"fmt"
 	"hash/crc32"
 	"io"
+	"io/ioutil"
 	"os"
-	"path/filepath"
+	"path"
 	"sort"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -48,10 +57,10 @@ func (s entrysort) find(dir, base, ext string) *entrypath {
 	return nil
 }
 func splitPath(rel string) (dir, base, ext string) {
-	rel = strings.ToLower(rel)
-	dir = filepath.ToSlash(filepath.Dir(rel))
-	base = filepath.Base(rel)
-	ext = filepath.Ext(rel)
+	rel, _ = cleanPath(rel)
+	dir = path.Dir(rel)
+	base = path.Base(rel)
+	ext = path.Ext(rel)
 
 	base = base[:len(base)-len(ext)]
 	if ext != "" {
@@ -71,6 +80,53 @@ func splitPath(rel string) (dir, base, ext string) {
 	return
 }
 
+// cleanPath converts rel to the form paths are stored in the VPK, the same way
+// the Source engine's filesystem does: backslashes are converted to forward
+// slashes, ASCII letters are converted to lowercase, empty and "." elements
+// are removed, and ".." removes the element before it. If rel uses ".." to
+// refer to something outside the VPK, ok is false.
+func cleanPath(rel string) (clean string, ok bool) {
+	elems := strings.Split(lowerASCII(strings.Replace(rel, "\\", "/", -1)), "/")
+
+	n := 0
+	ok = true
+	for _, e := range elems {
+		switch e {
+		case "", ".":
+		case "..":
+			if n == 0 {
+				ok = false
+			} else {
+				n--
+			}
+		default:
+			elems[n] = e
+			n++
+		}
+	}
+
+	return strings.Join(elems[:n], "/"), ok
+}
+
+// lowerASCII returns s with the ASCII letters A-Z converted to lowercase. Other
+// bytes, including those that are part of multi-byte UTF-8 sequences, are left
+// alone, just like the engine's case-insensitive comparisons.
+func lowerASCII(s string) string {
+	for i := 0; i < len(s); i++ {
+		if 'A' <= s[i] && s[i] <= 'Z' {
+			b := []byte(s)
+			for j := i; j < len(b); j++ {
+				if 'A' <= b[j] && b[j] <= 'Z' {
+					b[j] += 'a' - 'A'
+				}
+			}
+			return string(b)
+		}
+	}
+
+	return s
+}
+
 type entrypath struct {
 	// The filename is of the format dir/base.ext. If any component is empty
 	// in the actual filename, it is represented by a single space here.
@@ -81,6 +137,10 @@ type entrypath struct {
 	vpk *vpkentry
 	pre []byte
 	ent Entry
+
+	// Respawn VPKs store each file as a list of chunks instead of using the
+	// Offset and Length fields of vpk.
+	chunks []respawnChunk
 }
 
 type vpkentry struct {
@@ -103,27 +163,115 @@ type vpkentry struct {
 	Terminator uint16
 }
 
+// vpkheader2 is the part of the header that follows the tree length in
+// version 2 VPK files.
+type vpkheader2 struct {
+	// The number of bytes of file data stored in the directory file after
+	// the directory tree (ArchiveIndex == 0x7fff).
+	FileDataSectionSize uint32
+	// The number of bytes in the archive MD5 section, which follows the
+	// file data section.
+	ArchiveMD5SectionSize uint32
+	// The number of bytes in the other MD5 section, which follows the
+	// archive MD5 section. This is always 48.
+	OtherMD5SectionSize uint32
+	// The number of bytes in the signature section, which follows the
+	// other MD5 section.
+	SignatureSectionSize uint32
+}
+
+// Format identifies the family of file formats a VPK belongs to.
+type Format int
+
+const (
+	// FormatValve is Valve Software's VPK format, versions 1 and 2, or a
+	// headerless VPK.
+	FormatValve Format = iota
+	// FormatRespawn is the variant of Valve's format used by Respawn
+	// Entertainment's games.
+	FormatRespawn
+	// FormatBloodlines is the unrelated VPK format used by Vampire: The
+	// Masquerade - Bloodlines.
+	FormatBloodlines
+)
+
 type VPK struct {
 	opener     Opener
+	format     Format
 	version    uint32
 	treeLength uint32
+	header2    vpkheader2
 	entries    entrysort
 	modtime    time.Time
+
+	decompressLZHAM func(dst, src []byte) error
+
+	problems []Problem
+
+	dirsOnce sync.Once
+	dirs     map[string]*dirNode
+}
+
+// headerLength returns the number of bytes in the VPK header, which comes
+// before the directory tree.
+func (v *VPK) headerLength() int64 {
+	if v.format == FormatBloodlines {
+		return 0
+	}
+
+	switch v.version {
+	case 0:
+		return 0
+	case 2:
+		return 28
+	case respawnVersion:
+		return 16
+	default:
+		return 12
+	}
+}
+
+// Version returns the version number from the VPK header, or 0 if the VPK
+// does not have a header.
+func (v *VPK) Version() uint32 {
+	return v.version
+}
+
+// Problems returns the problems found while reading the directory tree in
+// lenient mode.
+func (v *VPK) Problems() []Problem {
+	return v.problems
+}
+
+// Format returns the family of file formats the VPK belongs to.
+func (v *VPK) Format() Format {
+	return v.format
+}
+
+// dataOffset returns the offset of the embedded file data (ArchiveIndex ==
+// 0x7fff) from the start of the main VPK file.
+func (v *VPK) dataOffset() int64 {
+	return v.headerLength() + int64(v.treeLength)
 }
 
 type vpkFileEntry struct {
+	entryStat
 	o Opener
-	l uint32
-	r string
+	b int64
 	e vpkentry
 	p []byte
 }
 
-func (e *vpkFileEntry) Rel() string {
-	return e.r
+func (e *vpkFileEntry) Open() (io.ReadCloser, error) {
+	offset := int64(e.e.Offset)
+	if e.e.ArchiveIndex == 0x7fff {
+		offset += e.b
+	}
+
+	return wrapErrors(e.open, e.r, e.e.ArchiveIndex, offset)
 }
 
-func (e *vpkFileEntry) Open() (io.ReadCloser, error) {
+func (e *vpkFileEntry) open() (io.ReadCloser, error) {
 	if e.e.Length == 0 {
 		return crcReader(bytes.NewReader(e.p), func() error { return nil }, e.e.CRC), nil
 	}
@@ -138,7 +286,7 @@ func (e *vpkFileEntry) Open() (io.ReadCloser, error) {
 			}
 			return nil, err
 		}
-		_, err = f.Seek(12+int64(e.l), os.SEEK_CUR)
+		_, err = f.Seek(e.b, os.SEEK_CUR)
 	} else {
 		f, err = e.o.Archive(e.e.ArchiveIndex)
 	}
@@ -150,6 +298,10 @@ func (e *vpkFileEntry) Open() (io.ReadCloser, error) {
 	}
 
 	_, err = f.Seek(int64(e.e.Offset), os.SEEK_CUR)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
 
 	return crcReader(io.MultiReader(bytes.NewReader(e.p), io.LimitReader(f, int64(e.e.Length))), f.Close, e.e.CRC), nil
 }
@@ -157,13 +309,65 @@ func (e *vpkFileEntry) Open() (io.ReadCloser, error) {
 // Entry returns the file with the given relative path, or nil if no such file
 // exists. The Close method of the io.ReadCloser returned by Entry.Open verifies
 // the CRC of the file.
+//
+// The path is normalized the same way the Source engine does it, so it may use
+// backslashes, "." and ".." elements, and uppercase letters. The Rel method of
+// the returned Entry returns the path as it is stored in the VPK.
 func (v *VPK) Entry(rel string) Entry {
+	if _, ok := cleanPath(rel); !ok {
+		return nil
+	}
+
 	e := v.entries.find(splitPath(rel))
 	if e == nil {
 		return nil
 	}
 
-	return &vpkFileEntry{v.opener, v.treeLength, rel, *e.vpk, e.pre}
+	return v.entry(joinPath(e.dir, e.base, e.ext), e)
+}
+
+func (v *VPK) entry(rel string, e *entrypath) Entry {
+	s := entryStat{rel, v.info(e), v.modtime}
+
+	if v.format == FormatBloodlines {
+		return &bloodlinesFileEntry{s, v.opener, *e.vpk}
+	}
+	if e.chunks != nil {
+		return &respawnFileEntry{s, v.opener, v.dataOffset(), *e.vpk, e.pre, e.chunks, v.decompressLZHAM}
+	}
+
+	return &vpkFileEntry{s, v.opener, v.dataOffset(), *e.vpk, e.pre}
+}
+
+// info returns the storage details of e.
+func (v *VPK) info(e *entrypath) EntryInfo {
+	info := EntryInfo{
+		CRC:          e.vpk.CRC,
+		PreloadBytes: e.vpk.PreloadBytes,
+		ArchiveIndex: e.vpk.ArchiveIndex,
+		Offset:       int64(e.vpk.Offset),
+		Length:       int64(e.vpk.Length),
+	}
+
+	if e.chunks != nil {
+		info.Length = 0
+		for i, c := range e.chunks {
+			if i == 0 {
+				info.Offset = int64(c.Offset)
+			}
+			info.Length += int64(c.CompressedLength)
+			info.Size += int64(c.Length)
+		}
+	} else {
+		info.Size = info.Length
+	}
+	info.Size += int64(info.PreloadBytes)
+
+	if info.ArchiveIndex == 0x7fff {
+		info.Offset += v.dataOffset()
+	}
+
+	return info
 }
 
 // Paths returns a slice containing the relative paths of all files in the VPK.
@@ -171,20 +375,98 @@ func (v *VPK) Paths() []string {
 	paths := make([]string, len(v.entries))
 
 	for i, e := range v.entries {
-		if e.dir != " " {
-			paths[i] += e.dir + "/"
-		}
-		if e.base != " " {
-			paths[i] += e.base
-		}
-		if e.ext != " " {
-			paths[i] += "." + e.ext
-		}
+		paths[i] = joinPath(e.dir, e.base, e.ext)
 	}
 
 	return paths
 }
 
+// joinPath is the inverse of splitPath. Components that are empty are treated
+// the same as components that are a single space.
+func joinPath(dir, base, ext string) string {
+	var rel string
+	if dir != " " && dir != "" {
+		rel += dir + "/"
+	}
+	if base != " " {
+		rel += base
+	}
+	if ext != " " && ext != "" {
+		rel += "." + ext
+	}
+	return rel
+}
+
+// EntryInfo describes how a file is stored in a VPK.
+type EntryInfo struct {
+	// Size is the size of the file in bytes, including preloaded data.
+	Size int64
+	// CRC is the IEEE CRC32 checksum of the file. VPKs from Vampire: The
+	// Masquerade - Bloodlines do not have checksums, so it is always 0 for
+	// those.
+	CRC uint32
+	// PreloadBytes is the number of bytes of the file that are stored in
+	// the directory tree.
+	PreloadBytes uint16
+	// ArchiveIndex is the index of the archive the rest of the file is
+	// stored in. 0x7fff is the main VPK file.
+	ArchiveIndex int16
+	// Offset is the position of the rest of the file from the beginning of
+	// the archive. For ArchiveIndex 0x7fff, it is from the beginning of
+	// the main VPK file rather than the end of the directory tree.
+	Offset int64
+	// Length is the number of bytes stored starting at Offset. For
+	// compressed files, it is the total compressed size of the chunks,
+	// which do not need to be contiguous.
+	Length int64
+}
+
+// StatEntry is an Entry that can describe how it is stored without being
+// opened. The Entry values returned by VPK.Entry implement StatEntry.
+type StatEntry interface {
+	Entry
+
+	// Info returns the storage details of the file.
+	Info() EntryInfo
+
+	// Stat returns information about the file. The Sys method of the
+	// os.FileInfo returns a *EntryInfo.
+	Stat() (os.FileInfo, error)
+}
+
+var (
+	_ StatEntry = (*vpkFileEntry)(nil)
+	_ StatEntry = (*respawnFileEntry)(nil)
+	_ StatEntry = (*bloodlinesFileEntry)(nil)
+)
+
+// entryStat implements the methods of StatEntry that are the same for every
+// VPK format.
+type entryStat struct {
+	r string
+	i EntryInfo
+	t time.Time
+}
+
+func (s *entryStat) Rel() string {
+	return s.r
+}
+
+func (s *entryStat) Info() EntryInfo {
+	return s.i
+}
+
+func (s *entryStat) Stat() (os.FileInfo, error) {
+	info := s.i
+	return &httpFileInfo{
+		name:    path.Base(s.r),
+		isDir:   false,
+		modTime: s.t,
+		size:    info.Size,
+		sys:     &info,
+	}, nil
+}
+
 type Entry interface {
 	// The relative path to this file.
 	Rel() string
@@ -202,10 +484,116 @@ type File interface {
 	Stat() (os.FileInfo, error)
 }
 
+// OpenOptions holds optional settings for OpenWithOptions. A nil *OpenOptions
+// is the same as the zero value.
+type OpenOptions struct {
+	// VerifyMD5 causes the tree, archive MD5 section, and whole file MD5
+	// checksums of version 2 VPKs to be checked. If any of them do not
+	// match, ErrMD5Mismatch is returned. It has no effect on version 1
+	// VPKs, which do not have checksums.
+	VerifyMD5 bool
+
+	// AllowHeaderless allows VPKs without a header, where the directory
+	// tree starts at the beginning of the main VPK file, to be opened
+	// instead of returning ErrInvalidMagic. Such VPKs are reported as
+	// version 0. Because there is no header to identify the file, it is
+	// only treated as a VPK if the directory tree is intact: it must end
+	// where expected, contain at least one file, and every file stored in
+	// the main VPK file must be within its bounds. Otherwise,
+	// ErrInvalidMagic is still returned, even in lenient mode.
+	AllowHeaderless bool
+
+	// DecompressLZHAM is used to decompress the chunks of files in VPKs
+	// from Respawn Entertainment's games, which are compressed using LZHAM
+	// with a 1 MiB dictionary. dst has the exact length of the
+	// decompressed chunk. This package does not include an LZHAM decoder,
+	// so if DecompressLZHAM is nil, reading a compressed file returns
+	// ErrUnsupportedCompression. This includes VPKs opened by OpenPath and
+	// files read through FS and the http.FileSystem. Uncompressed files
+	// can be read either way.
+	DecompressLZHAM func(dst, src []byte) error
+
+	// Strict causes the directory tree to be checked against the header
+	// and the size of the main VPK file. If the number of bytes in the
+	// directory tree does not match the header, ErrTreeLengthMismatch is
+	// returned. If the data for a file stored in the main VPK file is
+	// outside of the file (or, for version 2 VPKs, outside of the file
+	// data section), ErrInvalidEntry is returned.
+	Strict bool
+
+	// Limits restricts the resources used while reading the directory
+	// tree. If Limits is nil, there are no limits.
+	Limits *Limits
+
+	// Lenient causes problems with the directory tree to be recorded
+	// instead of returned as errors. Entries that cannot be read are
+	// skipped, and if the rest of the directory tree cannot be read, the
+	// entries that were read successfully are kept. The problems are
+	// available from the Problems method of the returned VPK. Problems
+	// with the header are still returned as errors.
+	Lenient bool
+}
+
+// Problem describes part of a directory tree that could not be read in
+// lenient mode.
+type Problem struct {
+	// Offset is the position in the main VPK file where the problem was
+	// found.
+	Offset int64
+	// Path is as much of the path of the affected file as was read before
+	// the problem was found. It is empty if the problem was not related to
+	// a specific file.
+	Path string
+	// Err describes the problem.
+	Err error
+}
+
+func (p Problem) String() string {
+	if p.Path == "" {
+		return fmt.Sprintf("offset %d: %v", p.Offset, p.Err)
+	}
+	return fmt.Sprintf("offset %d: %s: %v", p.Offset, p.Path, p.Err)
+}
+
+// Limits restricts the resources used while reading the directory tree of a
+// VPK, which may be useful when opening VPKs from untrusted sources. If a
+// limit is exceeded, ErrLimitExceeded is returned. A zero value for any field
+// means that there is no limit.
+type Limits struct {
+	// MaxEntries is the maximum number of files in the VPK.
+	MaxEntries int
+	// MaxStringLength is the maximum length in bytes of each extension,
+	// directory, and file name in the directory tree.
+	MaxStringLength int
+	// MaxPreloadBytes is the maximum total length of the preloaded data
+	// stored in the directory tree, which is kept in memory.
+	MaxPreloadBytes int64
+	// MaxTreeLength is the maximum length in bytes of the directory tree.
+	// Whether or not it is set, the directory tree is not allowed to be
+	// longer than the main VPK file when Limits is non-nil, and is not read
+	// past the length given in the header.
+	MaxTreeLength int64
+}
+
+// Open reads the directory tree of the VPK opened by o.
 func Open(o Opener) (*VPK, error) {
+	return OpenWithOptions(o, nil)
+}
+
+// OpenWithOptions is like Open, but allows additional checks to be performed.
+func OpenWithOptions(o Opener, opts *OpenOptions) (*VPK, error) {
+	if opts == nil {
+		opts = &OpenOptions{}
+	}
+	limits := opts.Limits
+	if limits == nil {
+		limits = &Limits{}
+	}
+
 	var vpk VPK
 
 	vpk.opener = o
+	vpk.decompressLZHAM = opts.DecompressLZHAM
 
 	r, err := o.Main()
 	if err != nil {
@@ -224,77 +612,222 @@ func Open(o Opener) (*VPK, error) {
 
 	var magic uint32
 	err = binary.Read(br, binary.LittleEndian, &magic)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		// too short to be a VPK of any kind.
+		return nil, ErrInvalidMagic
+	}
 	if err != nil {
 		return nil, err
 	}
 
+	// headerless is set if the file is being parsed as a directory tree
+	// without a header. Any file could be one, so if anything about the
+	// tree is wrong, the file is assumed to not be a VPK at all, and
+	// ErrInvalidMagic is returned instead.
+	headerless := false
+	notVPK := false
+
 	if magic != 0x55aa1234 {
-		return nil, ErrInvalidMagic
-	}
+		entries, ok, err := readBloodlines(r, fi.Size(), limits)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			vpk.format = FormatBloodlines
+			vpk.entries = entries
+			sort.Sort(vpk.entries)
 
-	err = binary.Read(br, binary.LittleEndian, &vpk.version)
-	if err != nil {
-		return nil, err
+			return &vpk, nil
+		}
+
+		if !opts.AllowHeaderless {
+			return nil, ErrInvalidMagic
+		}
+
+		// The directory tree starts at the beginning of the file.
+		headerless = true
+		_, err = r.Seek(0, os.SEEK_SET)
+		if err != nil {
+			return nil, err
+		}
+		br.Reset(r)
+	} else {
+		err = binary.Read(br, binary.LittleEndian, &vpk.version)
+		if err != nil {
+			return nil, err
+		}
+
+		if vpk.version != 1 && vpk.version != 2 && vpk.version != respawnVersion {
+			return nil, ErrUnsupportedVersion(vpk.version)
+		}
+
+		err = binary.Read(br, binary.LittleEndian, &vpk.treeLength)
+		if err != nil {
+			return nil, err
+		}
+
+		if vpk.version == respawnVersion {
+			vpk.format = FormatRespawn
+
+			// Respawn VPKs have a signature length, which is always 0.
+			var signatureLength uint32
+			err = binary.Read(br, binary.LittleEndian, &signatureLength)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		if vpk.version == 2 {
+			err = binary.Read(br, binary.LittleEndian, &vpk.header2)
+			if err != nil {
+				return nil, err
+			}
+		}
 	}
 
-	if vpk.version != 1 {
-		return nil, ErrUnsupportedVersion(vpk.version)
+	if opts.Limits != nil && vpk.version != 0 {
+		maxTreeLength := fi.Size() - vpk.headerLength()
+		if limits.MaxTreeLength != 0 && limits.MaxTreeLength < maxTreeLength {
+			maxTreeLength = limits.MaxTreeLength
+		}
+		if int64(vpk.treeLength) > maxTreeLength {
+			return nil, ErrLimitExceeded{Limit: "MaxTreeLength", Max: maxTreeLength}
+		}
 	}
 
-	// TODO: verify treeLength
-	err = binary.Read(br, binary.LittleEndian, &vpk.treeLength)
-	if err != nil {
-		return nil, err
+	tr := &countingReader{r: br, maxString: limits.MaxStringLength}
+	if opts.Limits != nil && !headerless {
+		// Don't trust the tree to end where the header says it does.
+		tr.r = bufio.NewReader(io.LimitReader(br, int64(vpk.treeLength)))
+	}
+	var preloadBytes int64
+
+	// problem records a problem with the directory tree in lenient mode, or
+	// returns err with its location otherwise.
+	problem := func(offset int64, dir, base, ext string, err error) error {
+		if headerless {
+			notVPK = true
+			return ErrInvalidMagic
+		}
+		if !opts.Lenient {
+			return &Error{
+				Op:           "parse",
+				Path:         joinPath(dir, base, ext),
+				ArchiveIndex: 0x7fff,
+				Offset:       offset,
+				Err:          err,
+			}
+		}
+		vpk.problems = append(vpk.problems, Problem{
+			Offset: offset,
+			Path:   joinPath(dir, base, ext),
+			Err:    err,
+		})
+		return nil
 	}
 
+tree:
 	for {
-		ext, err := br.ReadString(0)
+		offset := vpk.headerLength() + tr.n
+		ext, err := tr.ReadString(0)
 		if err != nil {
-			return nil, err
+			if err = problem(offset, "", "", "", err); err != nil {
+				return nil, err
+			}
+			break tree
 		}
 		ext = ext[:len(ext)-1]
 		if ext == "" {
 			break
 		}
 		for {
-			dir, err := br.ReadString(0)
+			offset := vpk.headerLength() + tr.n
+			dir, err := tr.ReadString(0)
 			if err != nil {
-				return nil, err
+				if err = problem(offset, "", "", ext, err); err != nil {
+					return nil, err
+				}
+				break tree
 			}
 			dir = dir[:len(dir)-1]
 			if dir == "" {
 				break
 			}
 			for {
-				base, err := br.ReadString(0)
+				offset := vpk.headerLength() + tr.n
+				base, err := tr.ReadString(0)
 				if err != nil {
-					return nil, err
+					if err = problem(offset, dir, "", ext, err); err != nil {
+						return nil, err
+					}
+					break tree
 				}
 				base = base[:len(base)-1]
 				if base == "" {
 					break
 				}
 
+				offset = vpk.headerLength() + tr.n
 				var e vpkentry
-				err = binary.Read(br, binary.LittleEndian, &e)
+				var chunks []respawnChunk
+				valid := true
+				if vpk.version == respawnVersion {
+					e, chunks, valid, err = readRespawnEntry(tr)
+				} else {
+					err = binary.Read(tr, binary.LittleEndian, &e)
+				}
 				if err != nil {
-					return nil, err
+					if err = problem(offset, dir, base, ext, err); err != nil {
+						return nil, err
+					}
+					break tree
 				}
 
-				if e.ArchiveIndex < 0 || e.Terminator != 0xffff {
-					return nil, ErrInvalidEntry{
+				if !valid || e.ArchiveIndex < 0 || e.Terminator != 0xffff {
+					err = ErrInvalidEntry{
 						Dir:  dir,
 						Base: base,
 						Ext:  ext,
 					}
+					if err = problem(offset, dir, base, ext, err); err != nil {
+						return nil, err
+					}
+
+					// In lenient mode, skip this entry and hope
+					// that the next one is intact.
+					_, err = io.CopyN(ioutil.Discard, tr, int64(e.PreloadBytes))
+					if err != nil {
+						problem(offset, dir, base, ext, err)
+						break tree
+					}
+					continue
+				}
+
+				if limits.MaxEntries != 0 && len(vpk.entries) >= limits.MaxEntries {
+					err = ErrLimitExceeded{Limit: "MaxEntries", Max: int64(limits.MaxEntries)}
+					if err = problem(offset, dir, base, ext, err); err != nil {
+						return nil, err
+					}
+					break tree
+				}
+				preloadBytes += int64(e.PreloadBytes)
+				if limits.MaxPreloadBytes != 0 && preloadBytes > limits.MaxPreloadBytes {
+					err = ErrLimitExceeded{Limit: "MaxPreloadBytes", Max: limits.MaxPreloadBytes}
+					if err = problem(offset, dir, base, ext, err); err != nil {
+						return nil, err
+					}
+					break tree
 				}
 
 				var pre []byte
 				if e.PreloadBytes != 0 {
 					pre = make([]byte, e.PreloadBytes)
-					_, err = io.ReadFull(br, pre)
+					_, err = io.ReadFull(tr, pre)
 					if err != nil {
-						return nil, err
+						if err = problem(offset, dir, base, ext, err); err != nil {
+							return nil, err
+						}
+						break tree
 					}
 				}
 
@@ -305,17 +838,128 @@ func Open(o Opener) (*VPK, error) {
 
 					vpk: &e,
 					pre: pre,
+
+					chunks: chunks,
 				})
 			}
 		}
 	}
 
+	if headerless {
+		if notVPK || len(vpk.entries) == 0 || tr.n != int64(uint32(tr.n)) {
+			return nil, ErrInvalidMagic
+		}
+
+		// Headerless VPKs have no tree length, so the embedded data
+		// starts immediately after the directory tree.
+		vpk.treeLength = uint32(tr.n)
+	} else if opts.Strict && tr.n != int64(vpk.treeLength) {
+		err = ErrTreeLengthMismatch{Actual: tr.n, Expected: vpk.treeLength}
+		if err = problem(vpk.headerLength()+tr.n, "", "", "", err); err != nil {
+			return nil, err
+		}
+	}
+
+	if opts.Strict || headerless {
+		dataLength := fi.Size() - vpk.dataOffset()
+		if vpk.version == 2 && int64(vpk.header2.FileDataSectionSize) < dataLength {
+			dataLength = int64(vpk.header2.FileDataSectionSize)
+		}
+
+		entries := vpk.entries[:0]
+		for _, e := range vpk.entries {
+			if e.vpk.ArchiveIndex == 0x7fff && e.chunks == nil && int64(e.vpk.Offset)+int64(e.vpk.Length) > dataLength {
+				err = ErrInvalidEntry{
+					Dir:  e.dir,
+					Base: e.base,
+					Ext:  e.ext,
+				}
+				if err = problem(vpk.dataOffset()+int64(e.vpk.Offset), e.dir, e.base, e.ext, err); err != nil {
+					return nil, err
+				}
+				continue
+			}
+			entries = append(entries, e)
+		}
+		vpk.entries = entries
+	}
+
 	sort.Sort(vpk.entries)
 
+	if opts.VerifyMD5 && vpk.version == 2 {
+		if err = vpk.verifyOtherMD5(r); err != nil {
+			offset := vpk.signatureOffset() - otherMD5Size
+			if !opts.Lenient {
+				return nil, &Error{
+					Op:           "verify",
+					ArchiveIndex: 0x7fff,
+					Offset:       offset,
+					Err:          err,
+				}
+			}
+			problem(offset, "", "", "", err)
+		}
+	}
+
 	return &vpk, nil
 }
 
-func Create(c Creator, contents []Entry, maxSize int64) (err error) {
+// CreateOptions holds optional settings for CreateWithOptions. A nil
+// *CreateOptions is the same as the zero value.
+type CreateOptions struct {
+	// Version is the VPK version to write. Versions 1 and 2 are supported.
+	// If Version is 0, version 1 is written.
+	Version uint32
+
+	// PrivateKey, if non-nil, is used to sign the VPK. Signing requires
+	// Version to be 2; otherwise, ErrSigningRequiresVersion2 is returned.
+	PrivateKey *rsa.PrivateKey
+}
+
+// Create writes a version 1 VPK containing contents to c. If maxSize is
+// negative, the file data is stored in the main VPK file. Otherwise, the file
+// data is split into archives of approximately maxSize bytes.
+//
+// The paths of the files are normalized the same way as in VPK.Entry. A path
+// that refers to something outside the VPK causes ErrInvalidPath to be
+// returned, and two paths that refer to the same file cause ErrDuplicatePath
+// to be returned.
+func Create(c Creator, contents []Entry, maxSize int64) error {
+	return CreateWithOptions(c, contents, maxSize, nil)
+}
+
+// CreateWithOptions is like Create, but allows the VPK version and other
+// settings to be chosen.
+func CreateWithOptions(c Creator, contents []Entry, maxSize int64, opts *CreateOptions) (err error) {
+	if opts == nil {
+		opts = &CreateOptions{}
+	}
+
+	version := opts.Version
+	if version == 0 {
+		version = 1
+	}
+	if version != 1 && version != 2 {
+		return ErrUnsupportedVersion(version)
+	}
+	if opts.PrivateKey != nil && version != 2 {
+		return ErrSigningRequiresVersion2
+	}
+
+	// Check the paths before doing anything else, so that nothing is
+	// written if two of them would end up as the same file.
+	paths := make(map[string]string, len(contents))
+	for _, c := range contents {
+		rel, ok := cleanPath(c.Rel())
+		if !ok || rel == "" {
+			return &Error{Op: "create", Path: c.Rel(), ArchiveIndex: -1, Offset: -1, Err: ErrInvalidPath}
+		}
+		if other, ok := paths[rel]; ok {
+			return &Error{Op: "create", Path: c.Rel(), ArchiveIndex: -1, Offset: -1, Err: ErrDuplicatePath(other)}
+		}
+		paths[rel] = c.Rel()
+	}
+
 	var entries []entrypath
 
 	hash := crc32.NewIEEE()
@@ -331,31 +975,46 @@ func Create(c Creator, contents []Entry, maxSize int64) (err error) {
 		e.Offset = offset
 		e.Terminator = 0xffff
 
+		fail := func(err error) error {
+			return &Error{
+				Op:           "create",
+				Path:         c.Rel(),
+				ArchiveIndex: -1,
+				Offset:       -1,
+				Err:          err,
+			}
+		}
+
+		if maxSize >= 0 && archive == 0x7fff {
+			// 0x7fff is reserved for the main VPK file.
+			return fail(ErrInvalidArchiveIndex(archive))
+		}
+
 		r, err := c.Open()
 		if err != nil {
-			return err
+			return fail(err)
 		}
 
 		hash.Reset()
 		length, err := io.Copy(hash, r)
 		if err != nil {
 			r.Close()
-			return err
+			return fail(err)
 		}
 
 		err = r.Close()
 		if err != nil {
-			return err
+			return fail(err)
 		}
 
 		if length != int64(uint32(length)) {
-			return ErrFileTooBig
+			return fail(ErrFileTooBig)
 		}
 
 		e.CRC = hash.Sum32()
 		e.Length = uint32(length)
 		if offset+uint32(length) < offset {
-			return ErrFileTooBig
+			return fail(ErrFileTooBig)
 		}
 		offset += uint32(length)
 		if maxSize >= 0 && int64(offset) >= maxSize {
@@ -425,6 +1084,33 @@ func Create(c Creator, contents []Entry, maxSize int64) (err error) {
 		return ErrFileTooBig
 	}
 
+	var header2 vpkheader2
+	if version == 2 {
+		archiveSize := make(map[int16]uint32)
+		for _, e := range entries {
+			if e.vpk.ArchiveIndex == 0x7fff {
+				header2.FileDataSectionSize += e.vpk.Length
+			} else if end := e.vpk.Offset + e.vpk.Length; end > archiveSize[e.vpk.ArchiveIndex] {
+				archiveSize[e.vpk.ArchiveIndex] = end
+			}
+		}
+		var chunks uint32
+		for _, size := range archiveSize {
+			chunks += (size + archiveMD5ChunkSize - 1) / archiveMD5ChunkSize
+		}
+		header2.ArchiveMD5SectionSize = chunks * archiveMD5EntrySize
+		header2.OtherMD5SectionSize = otherMD5Size
+	}
+	var publicKey []byte
+	if opts.PrivateKey != nil {
+		publicKey, err = x509.MarshalPKIXPublicKey(&opts.PrivateKey.PublicKey)
+		if err != nil {
+			return
+		}
+		header2.SignatureSectionSize = 4 + uint32(len(publicKey)) + 4 + uint32(opts.PrivateKey.Size())
+	}
+	treeMD5 := md5.Sum(buf.Bytes())
+
 	f, err := c.Main()
 	if err != nil {
 		return
@@ -442,24 +1128,53 @@ func Create(c Creator, contents []Entry, maxSize int64) (err error) {
 		}
 	}()
 
-	err = binary.Write(w, binary.LittleEndian, uint32(0x55aa1234)) // magic
+	// sw is used for everything in the main file before the signature
+	// section, which is its own signature. mw is used for everything in the
+	// main file up to the whole file MD5 checksum, which is its own MD5
+	// checksum.
+	wholeMD5, signed := md5.New(), sha256.New()
+	var sw, mw io.Writer = w, w
+	if version == 2 {
+		sw = io.MultiWriter(w, signed)
+		mw = io.MultiWriter(sw, wholeMD5)
+	}
+
+	err = binary.Write(mw, binary.LittleEndian, uint32(0x55aa1234)) // magic
 	if err != nil {
 		return
 	}
-	err = binary.Write(w, binary.LittleEndian, uint32(0x1)) // version
+	err = binary.Write(mw, binary.LittleEndian, version)
 	if err != nil {
 		return
 	}
-	err = binary.Write(w, binary.LittleEndian, uint32(buf.Len()))
+	err = binary.Write(mw, binary.LittleEndian, uint32(buf.Len()))
 	if err != nil {
 		return
 	}
-	_, err = buf.WriteTo(w)
+	if version == 2 {
+		err = binary.Write(mw, binary.LittleEndian, &header2)
+		if err != nil {
+			return
+		}
+	}
+	_, err = buf.WriteTo(mw)
 	if err != nil {
 		return
 	}
 
-	copyFile := func(w io.Writer, e entrypath) error {
+	copyFile := func(w io.Writer, e entrypath) (err error) {
+		defer func() {
+			if err != nil {
+				err = &Error{
+					Op:           "create",
+					Path:         e.ent.Rel(),
+					ArchiveIndex: e.vpk.ArchiveIndex,
+					Offset:       int64(e.vpk.Offset),
+					Err:          err,
+				}
+			}
+		}()
+
 		r, err := e.ent.Open()
 		if err != nil {
 			return err
@@ -480,9 +1195,10 @@ func Create(c Creator, contents []Entry, maxSize int64) (err error) {
 		return r.Close()
 	}
 
+	var chunks archiveMD5Writer
 	if maxSize < 0 {
 		for _, e := range entries {
-			if err = copyFile(w, e); err != nil {
+			if err = copyFile(mw, e); err != nil {
 				return
 			}
 		}
@@ -503,11 +1219,77 @@ func Create(c Creator, contents []Entry, maxSize int64) (err error) {
 				if a, err = c.Archive(i); err != nil {
 					return
 				}
+				chunks.next(i)
+			}
+			var aw io.Writer = a
+			if version == 2 {
+				aw = io.MultiWriter(a, &chunks)
 			}
-			if err = copyFile(a, e); err != nil {
+			if err = copyFile(aw, e); err != nil {
+				a.Close()
 				return
 			}
 		}
+		if a != nil {
+			if err = a.Close(); err != nil {
+				return
+			}
+		}
+		chunks.flush()
+	}
+
+	if version == 2 {
+		var section bytes.Buffer
+		err = binary.Write(&section, binary.LittleEndian, chunks.entries)
+		if err != nil {
+			return
+		}
+		var other otherMD5
+		other.TreeMD5 = treeMD5
+		other.ArchiveMD5SectionMD5 = md5.Sum(section.Bytes())
+
+		_, err = section.WriteTo(mw)
+		if err != nil {
+			return
+		}
+		_, err = mw.Write(other.TreeMD5[:])
+		if err != nil {
+			return
+		}
+		_, err = mw.Write(other.ArchiveMD5SectionMD5[:])
+		if err != nil {
+			return
+		}
+		wholeMD5.Sum(other.WholeFileMD5[:0])
+		_, err = sw.Write(other.WholeFileMD5[:])
+		if err != nil {
+			return
+		}
+	}
+
+	if opts.PrivateKey != nil {
+		var sig []byte
+		sig, err = rsa.SignPKCS1v15(rand.Reader, opts.PrivateKey, crypto.SHA256, signed.Sum(nil))
+		if err != nil {
+			return
+		}
+
+		err = binary.Write(w, binary.LittleEndian, uint32(len(publicKey)))
+		if err != nil {
+			return
+		}
+		_, err = w.Write(publicKey)
+		if err != nil {
+			return
+		}
+		err = binary.Write(w, binary.LittleEndian, uint32(len(sig)))
+		if err != nil {
+			return
+		}
+		_, err = w.Write(sig)
+		if err != nil {
+			return
+		}
 	}
 
 	return
@@ -0,0 +1,573 @@
+package vpk
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+type testEntry struct {
+	rel  string
+	data []byte
+}
+
+func (e testEntry) Rel() string {
+	return e.rel
+}
+
+func (e testEntry) Open() (io.ReadCloser, error) {
+	return ioutil.NopCloser(bytes.NewReader(e.data)), nil
+}
+
+// testEntries returns a set of files that is big enough to be split between
+// several archives and several archive MD5 chunks.
+func testEntries() []Entry {
+	var entries []Entry
+	for i := 0; i < 20; i++ {
+		rel := fmt.Sprintf("dir%d/sub/file%d.txt", i%3, i)
+		entries = append(entries, testEntry{rel, bytes.Repeat([]byte{byte(i)}, i*100000)})
+	}
+	return append(entries,
+		testEntry{"root.txt", []byte("hello")},
+		testEntry{"noext", []byte("x")},
+		testEntry{"empty.bin", nil},
+	)
+}
+
+func readEntry(ent Entry) ([]byte, error) {
+	r, err := ent.Open()
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		r.Close()
+		return nil, err
+	}
+
+	return b, r.Close()
+}
+
+func checkEntries(t *testing.T, v *VPK, entries []Entry) {
+	t.Helper()
+
+	if len(v.Paths()) != len(entries) {
+		t.Fatalf("VPK has %d files, but %d were written", len(v.Paths()), len(entries))
+	}
+
+	for _, e := range entries {
+		ent := v.Entry(e.Rel())
+		if ent == nil {
+			t.Fatalf("%s: missing from VPK", e.Rel())
+		}
+
+		b, err := readEntry(ent)
+		if err != nil {
+			t.Fatalf("%s: %v", e.Rel(), err)
+		}
+		if !bytes.Equal(b, e.(testEntry).data) {
+			t.Fatalf("%s: contents do not match", e.Rel())
+		}
+	}
+}
+
+type testVPK struct {
+	creator Creator
+	opener  Opener
+	main    string
+	maxSize int64
+}
+
+func singleTestVPK(t *testing.T) testVPK {
+	name := filepath.Join(t.TempDir(), "test.vpk")
+	return testVPK{SingleVPKCreator(name), SingleVPK(name), name, -1}
+}
+
+func multiTestVPK(t *testing.T) testVPK {
+	prefix := filepath.Join(t.TempDir(), "test")
+	return testVPK{MultiVPKCreator(prefix), MultiVPK(prefix), prefix + "_dir.vpk", 3 << 20}
+}
+
+func (tv testVPK) create(t *testing.T, entries []Entry, opts *CreateOptions) {
+	t.Helper()
+
+	if err := CreateWithOptions(tv.creator, entries, tv.maxSize, opts); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// corrupt flips the bits of one byte in a file.
+func corrupt(t *testing.T, name string, offset int64) {
+	t.Helper()
+
+	f, err := os.OpenFile(name, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	var b [1]byte
+	if _, err = f.ReadAt(b[:], offset); err != nil {
+		t.Fatal(err)
+	}
+	b[0] ^= 0xff
+	if _, err = f.WriteAt(b[:], offset); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	for _, version := range []uint32{1, 2} {
+		for _, multi := range []bool{false, true} {
+			t.Run(fmt.Sprintf("v%d/multi=%v", version, multi), func(t *testing.T) {
+				tv := singleTestVPK(t)
+				if multi {
+					tv = multiTestVPK(t)
+				}
+
+				entries := testEntries()
+				tv.create(t, entries, &CreateOptions{Version: version})
+
+				v, err := OpenWithOptions(tv.opener, &OpenOptions{VerifyMD5: true, Strict: true})
+				if err != nil {
+					t.Fatal(err)
+				}
+				if v.Version() != version {
+					t.Errorf("version is %d, but %d was written", v.Version(), version)
+				}
+
+				checkEntries(t, v, entries)
+			})
+		}
+	}
+}
+
+func TestVerifyArchiveMD5(t *testing.T) {
+	tv := multiTestVPK(t)
+	tv.create(t, testEntries(), &CreateOptions{Version: 2})
+
+	v, err := Open(tv.opener)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	chunks, err := v.VerifyArchiveMD5()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(chunks) < 2 {
+		t.Errorf("expected several chunks, but there are %d", len(chunks))
+	}
+	for _, c := range chunks {
+		if !c.OK() {
+			t.Errorf("archive %d offset %d: %v", c.ArchiveIndex, c.Offset, c.Err)
+		}
+	}
+
+	corrupt(t, filepath.Join(filepath.Dir(tv.main), "test_001.vpk"), 1<<20+5)
+
+	chunks, err = v.VerifyArchiveMD5()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bad := 0
+	for _, c := range chunks {
+		if !c.OK() {
+			bad++
+			if c.Err != nil {
+				t.Errorf("archive %d offset %d: %v", c.ArchiveIndex, c.Offset, c.Err)
+			}
+		}
+	}
+	if bad != 1 {
+		t.Errorf("expected 1 bad chunk, but there are %d", bad)
+	}
+}
+
+func TestMD5Mismatch(t *testing.T) {
+	for offset, checksum := range map[int64]MD5Checksum{
+		40:   TreeMD5,
+		2000: WholeFileMD5,
+	} {
+		tv := singleTestVPK(t)
+		tv.create(t, testEntries(), &CreateOptions{Version: 2})
+		corrupt(t, tv.main, offset)
+
+		_, err := OpenWithOptions(tv.opener, &OpenOptions{VerifyMD5: true})
+
+		var mismatch ErrMD5Mismatch
+		if !errors.As(err, &mismatch) {
+			t.Fatalf("offset %d: expected ErrMD5Mismatch, got %v", offset, err)
+		}
+		if mismatch.Checksum != checksum {
+			t.Errorf("offset %d: expected %v mismatch, got %v", offset, checksum, mismatch.Checksum)
+		}
+		if !errors.Is(err, ErrCorrupt) {
+			t.Errorf("offset %d: %v is not ErrCorrupt", offset, err)
+		}
+	}
+}
+
+func TestCRCMismatch(t *testing.T) {
+	tv := singleTestVPK(t)
+	tv.create(t, []Entry{testEntry{"a.txt", []byte("hello, world")}}, nil)
+
+	info, err := os.Stat(tv.main)
+	if err != nil {
+		t.Fatal(err)
+	}
+	corrupt(t, tv.main, info.Size()-1)
+
+	v, err := Open(tv.opener)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = readEntry(v.Entry("a.txt"))
+
+	var mismatch ErrCRCMismatch
+	if !errors.As(err, &mismatch) {
+		t.Fatalf("expected ErrCRCMismatch, got %v", err)
+	}
+	if !errors.Is(err, ErrCorrupt) {
+		t.Errorf("%v is not ErrCorrupt", err)
+	}
+}
+
+func TestSignature(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tv := singleTestVPK(t)
+	tv.create(t, testEntries(), &CreateOptions{Version: 2, PrivateKey: key})
+
+	v, err := OpenWithOptions(tv.opener, &OpenOptions{VerifyMD5: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = v.VerifySignature(&key.PublicKey); err != nil {
+		t.Fatal(err)
+	}
+
+	other, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = v.VerifySignature(&other.PublicKey); err != ErrUntrustedKey {
+		t.Errorf("expected ErrUntrustedKey, got %v", err)
+	}
+
+	corrupt(t, tv.main, 3000)
+	if err = v.VerifySignature(nil); err != ErrInvalidSignature {
+		t.Errorf("expected ErrInvalidSignature, got %v", err)
+	}
+}
+
+func TestSignatureRequiresVersion2(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tv := singleTestVPK(t)
+	err = CreateWithOptions(tv.creator, testEntries(), tv.maxSize, &CreateOptions{Version: 1, PrivateKey: key})
+	if !errors.Is(err, ErrSigningRequiresVersion2) {
+		t.Errorf("expected ErrSigningRequiresVersion2, got %v", err)
+	}
+}
+
+func TestKeyFiles(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := MarshalPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	private, err := ParsePrivateKey(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !private.Equal(key) {
+		t.Error("private key does not match")
+	}
+
+	b, err = MarshalPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	public, err := ParsePublicKey(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !public.Equal(&key.PublicKey) {
+		t.Error("public key does not match")
+	}
+}
+
+func TestLenient(t *testing.T) {
+	tv := singleTestVPK(t)
+	entries := testEntries()
+	tv.create(t, entries, nil)
+
+	b, err := ioutil.ReadFile(tv.main)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// break the terminator of the first file in the "dir0/sub" directory.
+	i := bytes.Index(b, []byte("file0\x00"))
+	if i == -1 {
+		t.Fatal("could not find file0 in directory tree")
+	}
+	b[i+len("file0\x00")+16] = 0
+	if err = ioutil.WriteFile(tv.main, b, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = Open(tv.opener)
+
+	var invalid ErrInvalidEntry
+	if !errors.As(err, &invalid) {
+		t.Fatalf("expected ErrInvalidEntry, got %v", err)
+	}
+
+	v, err := OpenWithOptions(tv.opener, &OpenOptions{Lenient: true, Strict: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(v.Problems()) != 1 {
+		t.Errorf("expected 1 problem, got %v", v.Problems())
+	}
+	if len(v.Paths()) != len(entries)-1 {
+		t.Errorf("expected %d files, got %d", len(entries)-1, len(v.Paths()))
+	}
+	if v.Entry("dir0/sub/file0.txt") != nil {
+		t.Error("broken file was not skipped")
+	}
+	if _, err = readEntry(v.Entry("dir1/sub/file1.txt")); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestEntryNormalize(t *testing.T) {
+	tv := singleTestVPK(t)
+	tv.create(t, testEntries(), nil)
+
+	v, err := Open(tv.opener)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, rel := range []string{
+		"dir1/sub/file4.txt",
+		"/dir1/sub/file4.txt",
+		`DIR1\Sub\File4.TXT`,
+		"./dir1//sub/./file4.txt",
+		"dir1/x/../sub/file4.txt",
+	} {
+		ent := v.Entry(rel)
+		if ent == nil {
+			t.Errorf("%q: not found", rel)
+		} else if ent.Rel() != "dir1/sub/file4.txt" {
+			t.Errorf("%q: Rel returned %q", rel, ent.Rel())
+		}
+	}
+
+	for _, rel := range []string{
+		"../dir1/sub/file4.txt",
+		"dir1/../../dir1/sub/file4.txt",
+		"dir1/sub",
+	} {
+		if ent := v.Entry(rel); ent != nil {
+			t.Errorf("%q: found %q", rel, ent.Rel())
+		}
+	}
+
+	if s := lowerASCII("ÀÉbCK"); s != "ÀÉbck" {
+		t.Errorf("lowerASCII changed non-ASCII letters: %q", s)
+	}
+}
+
+func TestHeaderless(t *testing.T) {
+	tv := singleTestVPK(t)
+	entries := testEntries()
+	tv.create(t, entries, nil)
+
+	b, err := ioutil.ReadFile(tv.main)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = ioutil.WriteFile(tv.main, b[12:], 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = Open(tv.opener); err != ErrInvalidMagic {
+		t.Errorf("expected ErrInvalidMagic, got %v", err)
+	}
+
+	v, err := OpenPath(tv.main, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.Version() != 0 {
+		t.Errorf("expected version 0, got %d", v.Version())
+	}
+	checkEntries(t, v, entries)
+
+	dir := t.TempDir()
+	for name, contents := range map[string][]byte{
+		"text.vpk":      []byte("this is not a VPK\n"),
+		"empty.vpk":     {0},
+		"truncated.vpk": b[12:200],
+		"pak01_003.vpk": bytes.Repeat([]byte{3}, 1<<16),
+	} {
+		name = filepath.Join(dir, name)
+		if err = ioutil.WriteFile(name, contents, 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err = OpenPath(name, &OpenOptions{Lenient: true}); err != ErrInvalidMagic {
+			t.Errorf("%s: expected ErrInvalidMagic, got %v", filepath.Base(name), err)
+		}
+	}
+}
+
+func TestLimits(t *testing.T) {
+	tv := singleTestVPK(t)
+	tv.create(t, testEntries(), &CreateOptions{Version: 2})
+
+	for _, limits := range []Limits{
+		{MaxEntries: 5},
+		{MaxStringLength: 3},
+		{MaxTreeLength: 10},
+	} {
+		_, err := OpenWithOptions(tv.opener, &OpenOptions{Limits: &limits})
+
+		var exceeded ErrLimitExceeded
+		if !errors.As(err, &exceeded) {
+			t.Errorf("%+v: expected ErrLimitExceeded, got %v", limits, err)
+		}
+	}
+
+	if _, err := OpenWithOptions(tv.opener, &OpenOptions{Limits: &Limits{MaxEntries: 100}}); err != nil {
+		t.Fatal(err)
+	}
+
+	// claim that the tree is shorter than it is. The parser must not read
+	// past the claimed length.
+	f, err := os.OpenFile(tv.main, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = f.WriteAt([]byte{20, 0, 0, 0}, 8)
+	if e := f.Close(); err == nil {
+		err = e
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = OpenWithOptions(tv.opener, &OpenOptions{Limits: &Limits{}})
+	if err == nil {
+		t.Error("tree was read past the length in the header")
+	}
+}
+
+func TestBloodlines(t *testing.T) {
+	var b bytes.Buffer
+	b.WriteString("AAAAhello")
+	directoryOffset := b.Len()
+	for _, e := range []struct {
+		name           string
+		offset, length uint32
+	}{
+		{`materials\Foo.vmt`, 0, 4},
+		{"readme.txt", 4, 5},
+	} {
+		binary.Write(&b, binary.LittleEndian, uint32(len(e.name)))
+		b.WriteString(e.name)
+		binary.Write(&b, binary.LittleEndian, []uint32{e.offset, e.length})
+	}
+	binary.Write(&b, binary.LittleEndian, bloodlinesfooter{2, 0, uint32(directoryOffset)})
+
+	name := filepath.Join(t.TempDir(), "pack000.vpk")
+	if err := ioutil.WriteFile(name, b.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	v, err := OpenWithOptions(SingleVPK(name), &OpenOptions{Limits: &Limits{MaxEntries: 2}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.Format() != FormatBloodlines {
+		t.Errorf("format is %v", v.Format())
+	}
+	for rel, want := range map[string]string{"materials/foo.vmt": "AAAA", "readme.txt": "hello"} {
+		data, err := readEntry(v.Entry(rel))
+		if err != nil || string(data) != want {
+			t.Errorf("%s: expected %q, got %q (%v)", rel, want, data, err)
+		}
+	}
+
+	_, err = OpenWithOptions(SingleVPK(name), &OpenOptions{Limits: &Limits{MaxEntries: 1}})
+
+	var exceeded ErrLimitExceeded
+	if !errors.As(err, &exceeded) {
+		t.Errorf("expected ErrLimitExceeded, got %v", err)
+	}
+}
+
+func TestBloodlinesLimits(t *testing.T) {
+	// not a Bloodlines VPK, but the last 9 bytes look like a footer for a
+	// directory of 3 files.
+	b := append(bytes.Repeat([]byte{0xee}, 100), 3, 0, 0, 0, 0, 0, 0, 0, 0)
+
+	name := filepath.Join(t.TempDir(), "pack000.vpk")
+	if err := ioutil.WriteFile(name, b, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := OpenWithOptions(SingleVPK(name), &OpenOptions{Limits: &Limits{MaxEntries: 1}})
+	if err != ErrInvalidMagic {
+		t.Errorf("expected ErrInvalidMagic, got %v", err)
+	}
+}
+
+func TestCreateInvalidPath(t *testing.T) {
+	for _, rel := range []string{"../x.txt", "a/../../x.txt", "", "./"} {
+		tv := singleTestVPK(t)
+		err := Create(tv.creator, []Entry{testEntry{rel, []byte("x")}}, -1)
+		if !errors.Is(err, ErrInvalidPath) {
+			t.Errorf("%q: expected ErrInvalidPath, got %v", rel, err)
+		}
+	}
+
+	tv := singleTestVPK(t)
+	err := Create(tv.creator, []Entry{
+		testEntry{"a/b.txt", []byte("1")},
+		testEntry{`A\x\..\B.TXT`, []byte("2")},
+	}, -1)
+
+	var duplicate ErrDuplicatePath
+	if !errors.As(err, &duplicate) || string(duplicate) != "a/b.txt" {
+		t.Errorf("expected ErrDuplicatePath, got %v", err)
+	}
+	if _, err = os.Stat(tv.main); !os.IsNotExist(err) {
+		t.Errorf("VPK was written anyway: %v", err)
+	}
+}